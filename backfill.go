@@ -0,0 +1,400 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PhaseBackfill is the tracking Phase of an Iteration whose existing rows
+// are being copied in parallel ranges of keys
+const PhaseBackfill = "backfill"
+
+// backfillRange is a range of primary keys ( Lower, Upper ] copied by a
+// single backfill worker. Keys are encoded with EncodeKeyTuple, and an
+// empty Lower is unbounded. Position is the key of the last row copied
+// from the range, if any.
+type backfillRange struct {
+	Lower    string `json:"lower"`
+	Upper    string `json:"upper"`
+	Position string `json:"position"`
+	Done     bool   `json:"done"`
+}
+
+// backfillState is persisted as the PhaseState of an Iteration while it is
+// being backfilled, so that completed ranges are not copied again after a
+// restart.
+type backfillState struct {
+	Ranges []backfillRange `json:"ranges"`
+}
+
+// remaining returns the number of ranges which have not been completed.
+func (s backfillState) remaining() int {
+	out := 0
+	for _, r := range s.Ranges {
+		if !r.Done {
+			out++
+		}
+	}
+	return out
+}
+
+// backfillConnections is the number of additional source connections used
+// by the backfill of an Iteration.
+func backfillConnections(params *Parameters) int {
+	if params == nil || !paramBool(*params, ParamBackfill, false) {
+		return 0
+	}
+	return max(paramInt(*params, ParamBackfillThreads, 4), 1)
+}
+
+// backfill copies the existing rows of the source table of an Iteration by
+// splitting its primary keys into ranges which are extracted, transformed
+// and loaded by concurrent workers. The high-water mark of the Extractor
+// is recorded as the tracking position before any rows are copied, so
+// that changes made while the backfill runs are extracted afterwards, and
+// the progress of each range is persisted as the PhaseState of the
+// Iteration so that an interrupted backfill resumes where it stopped.
+// Unlike a snapshot, the rows are not read from a consistent view of the
+// source table and no locks are taken.
+func (m *Migrator) backfill(ctx context.Context, x int, ts TrackingStatus) (TrackingStatus, error) {
+	tag := "Migrator.backfill(): [" + m.trackingKey(x).String() + "] "
+	params := *m.Iterations[x].Parameters
+	threads := max(paramInt(params, ParamBackfillThreads, 4), 1)
+	batchSize := max(paramInt(params, ParamBatchSize, DefaultBatchSize), 1)
+	extractor := m.Iterations[x].ExtractorName
+
+	if _, ok := snapshotExtractors[extractor]; !ok {
+		return ts, errors.New(tag + "Extractor '" + extractor + "' does not support backfills")
+	}
+
+	keyCols, err := primaryKeyColumns(ctx, m.sourceDb, m.SourceDsn.DBName, m.Iterations[x].SourceTable)
+	if err != nil {
+		return ts, err
+	}
+	if len(keyCols) == 0 {
+		return ts, errors.New(tag + "Source table " + m.Iterations[x].SourceTable + " has no primary key")
+	}
+
+	var state backfillState
+	if ts.Phase == PhaseBackfill && ts.PhaseState != "" {
+		err = json.Unmarshal([]byte(ts.PhaseState), &state)
+		if err != nil {
+			return ts, fmt.Errorf("%sInvalid backfill state: %w", tag, err)
+		}
+		logger.Infof(tag+"Resuming backfill with %d of %d ranges remaining", state.remaining(), len(state.Ranges))
+	} else {
+		ts, state, err = m.planBackfill(ctx, x, ts, keyCols)
+		if err != nil {
+			return ts, err
+		}
+		logger.Infof(tag+"Starting backfill of %d ranges, high-water mark %s", len(state.Ranges), ts.String())
+	}
+
+	filter, err := backfillFilter(ts, extractor)
+	if err != nil {
+		return ts, err
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var stateMutex, transformMutex sync.Mutex
+	var firstErr error
+
+	// checkpoint persists the progress of a single range, along with that
+	// of every other range
+	checkpoint := func(r int, position []any, done bool) error {
+		stateMutex.Lock()
+		defer stateMutex.Unlock()
+		if position != nil {
+			encoded, err := EncodeKeyTuple(position)
+			if err != nil {
+				return err
+			}
+			state.Ranges[r].Position = encoded
+		}
+		state.Ranges[r].Done = done
+		out, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		ts.PhaseState = string(out)
+		err = m.trackingStore.Update(ts)
+		if err == nil && done {
+			logger.Infof(tag+"Completed range %d, %d of %d ranges remaining", r, state.remaining(), len(state.Ranges))
+		}
+		return err
+	}
+
+	work := make(chan int)
+	for range threads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range work {
+				stateMutex.Lock()
+				rng := state.Ranges[r]
+				stateMutex.Unlock()
+				err := m.backfillRange(ctx, x, keyCols, filter, rng, batchSize, &transformMutex, func(position []any, done bool) error {
+					return checkpoint(r, position, done)
+				})
+				if err != nil {
+					stateMutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					stateMutex.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+dispatch:
+	for r := range state.Ranges {
+		if state.Ranges[r].Done {
+			continue
+		}
+		select {
+		case work <- r:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(work)
+	wg.Wait()
+	if firstErr != nil {
+		return ts, firstErr
+	}
+	if ctx.Err() != nil {
+		return ts, ctx.Err()
+	}
+
+	ts.Phase = PhaseIncremental
+	ts.PhaseState = ""
+	ts.LastRun = NullTimeNow()
+	err = m.trackingStore.Update(ts)
+	if err != nil {
+		return ts, err
+	}
+	logger.Infof(tag+"Completed backfill of %d ranges in %s, continuing from %s", len(state.Ranges), time.Since(start).String(), ts.String())
+	return ts, nil
+}
+
+// planBackfill records the high-water mark of the Extractor of an
+// Iteration as its tracking position, then splits the primary keys of the
+// source table up to the last existing key into ranges, persisting both
+// before the backfill starts.
+func (m *Migrator) planBackfill(ctx context.Context, x int, ts TrackingStatus, keyCols []string) (TrackingStatus, backfillState, error) {
+	var state backfillState
+	table := m.Iterations[x].SourceTable
+	ranges := max(paramInt(*m.Iterations[x].Parameters, ParamBackfillRanges, 16), 1)
+
+	// The high-water mark is read before the last key, so that rows
+	// inserted in between are copied by both the backfill and the
+	// Extractor rather than by neither
+	var err error
+	if snapshotExtractors[m.Iterations[x].ExtractorName] {
+		ts, err = snapshotHighWaterMark(ctx, m.sourceDb, table, ts, m.Iterations[x].ExtractorName)
+		if err != nil {
+			return ts, state, err
+		}
+	}
+	last, err := lastKey(ctx, m.sourceDb, table, keyCols)
+	if err != nil {
+		return ts, state, err
+	}
+
+	if last != nil {
+		bounds, err := backfillBoundaries(ctx, m.sourceDb, table, keyCols, last, ranges)
+		if err != nil {
+			return ts, state, err
+		}
+		lower := ""
+		for _, bound := range bounds {
+			upper, err := EncodeKeyTuple(bound)
+			if err != nil {
+				return ts, state, err
+			}
+			state.Ranges = append(state.Ranges, backfillRange{Lower: lower, Upper: upper})
+			lower = upper
+		}
+	}
+
+	out, err := json.Marshal(state)
+	if err != nil {
+		return ts, state, err
+	}
+	ts.Phase = PhaseBackfill
+	ts.PhaseState = string(out)
+	return ts, state, m.trackingStore.Update(ts)
+}
+
+// backfillBoundaries finds the upper bounds of ranges of roughly equal
+// size covering the primary keys of a table up to the specified last key.
+// A single integer key is split evenly between its minimum and maximum;
+// other keys are split by counting rows.
+func backfillBoundaries(ctx context.Context, db *sql.DB, table string, keyCols []string, last []any, ranges int) ([][]any, error) {
+	if len(keyCols) == 1 {
+		first, maxKey, ok, err := integerKeyRange(ctx, db, table, keyCols[0])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out := make([][]any, 0, ranges)
+			step := (maxKey - first) / int64(ranges)
+			if step > 0 {
+				for i := 1; i < ranges; i++ {
+					out = append(out, []any{first + step*int64(i) - 1})
+				}
+			}
+			return append(out, []any{maxKey}), nil
+		}
+	}
+
+	var count int64
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM `"+table+"`").Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	chunkSize := int(count/int64(ranges)) + 1
+	out := make([][]any, 0, ranges)
+	var lower []any
+	for {
+		upper, err := chunkBoundary(ctx, db, table, keyCols, keyCondition{}.keyRange(keyCols, lower, last), chunkSize)
+		if err != nil {
+			return nil, err
+		}
+		if upper == nil {
+			break
+		}
+		out = append(out, upper)
+		lower = upper
+	}
+	return append(out, last), nil
+}
+
+// integerKeyRange finds the minimum and maximum of a key column, if it is
+// an integer column.
+func integerKeyRange(ctx context.Context, db *sql.DB, table, column string) (int64, int64, bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT MIN(`"+column+"`), MAX(`"+column+"`) FROM `"+table+"`")
+	if err != nil {
+		return 0, 0, false, err
+	}
+	defer rows.Close()
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	switch strings.ToUpper(colTypes[0].DatabaseTypeName()) {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT":
+	default:
+		return 0, 0, false, nil
+	}
+	if !rows.Next() {
+		return 0, 0, false, rows.Err()
+	}
+	var first, last sql.NullInt64
+	err = rows.Scan(&first, &last)
+	if err != nil {
+		// Unsigned keys beyond the range of an int64 are split by counting
+		return 0, 0, false, nil
+	}
+	return first.Int64, last.Int64, first.Valid && last.Valid, nil
+}
+
+// backfillFilter excludes rows beyond the high-water mark of Extractors
+// which may insert, rather than replace, the rows they extract, so that
+// rows inserted while a backfill runs are loaded only once.
+func backfillFilter(ts TrackingStatus, extractor string) (keyCondition, error) {
+	switch extractor {
+	case "sequential":
+		return keyCondition{}.and("`"+ts.ColumnName+"` <= ?", []any{ts.SequentialPosition}), nil
+	case "keyset":
+		if ts.KeyPosition == "" {
+			return keyCondition{}, nil
+		}
+		position, err := DecodeKeyTuple(ts.KeyPosition)
+		if err != nil {
+			return keyCondition{}, err
+		}
+		cols := keysetColumns(ts.ColumnName)
+		if len(position) != len(cols) {
+			return keyCondition{}, fmt.Errorf("key position %s does not match key columns %s", ts.KeyPosition, ts.ColumnName)
+		}
+		return keyCondition{}.and(keysetBefore(cols, position, true)), nil
+	}
+	return keyCondition{}, nil
+}
+
+// decodeKeyBound decodes a bound of a backfillRange, which is nil if
+// empty.
+func decodeKeyBound(s string) ([]any, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return DecodeKeyTuple(s)
+}
+
+// backfillRange copies a single range of keys through the Transformer and
+// Loader of an Iteration in batches, calling checkpoint with the key of
+// the last row copied after each batch. Transformers are not required to
+// be safe for concurrent use, so they are called one at a time.
+func (m *Migrator) backfillRange(ctx context.Context, x int, keyCols []string, filter keyCondition, r backfillRange, batchSize int, transformMutex *sync.Mutex, checkpoint func(position []any, done bool) error) error {
+	lower, err := decodeKeyBound(r.Lower)
+	if err != nil {
+		return err
+	}
+	if r.Position != "" {
+		lower, err = decodeKeyBound(r.Position)
+		if err != nil {
+			return err
+		}
+	}
+	upper, err := decodeKeyBound(r.Upper)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if !m.waitWhilePaused(ctx, x) {
+			return ctx.Err()
+		}
+
+		tsStart := time.Now()
+		rows, last, err := selectRows(ctx, m.sourceDb, m.Iterations[x].SourceTable, keyCols, filter.keyRange(keyCols, lower, upper), batchSize, "REPLACE")
+		if err != nil {
+			m.observeStage(x, StageBackfill, tsStart, err)
+			return err
+		}
+		if len(rows) > 0 {
+			m.observeExtracted(x, rows)
+
+			transformMutex.Lock()
+			data := m.Iterations[x].Transformer(m.DestinationDsn.DBName, m.Iterations[x].DestinationTable, rows, m.Iterations[x].TransformerParameters)
+			transformMutex.Unlock()
+
+			// An empty TrackingStatus prevents the Loader from moving the
+			// tracked position before the backfill is complete
+			err = m.Iterations[x].Loader(ctx, m.destinationDb, data, TrackingStatus{}, m.Iterations[x].Parameters)
+			m.observeStage(x, StageBackfill, tsStart, err)
+			if err != nil {
+				return err
+			}
+			m.observeLoaded(x, data)
+		}
+
+		done := len(rows) < batchSize
+		err = checkpoint(last, done)
+		if err != nil || done {
+			return err
+		}
+		lower = last
+	}
+}
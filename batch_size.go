@@ -0,0 +1,164 @@
+package migrator
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// batchSizer adjusts the BatchSize and InsertBatchSize of an Iteration
+// towards a target batch duration. Both sizes are kept in proportion to
+// their configured values by scaling them with the same factor.
+type batchSizer struct {
+	mutex           sync.Mutex
+	target          time.Duration
+	minSize         int
+	maxSize         int
+	baseBatch       int
+	baseInsertBatch int
+	scale           float64
+}
+
+func newBatchSizer(params Parameters) *batchSizer {
+	b := &batchSizer{
+		target:          time.Duration(max(paramInt(params, ParamBatchTargetDuration, 1000), 1)) * time.Millisecond,
+		minSize:         max(paramInt(params, ParamMinBatchSize, 10), 1),
+		baseBatch:       paramInt(params, ParamBatchSize, DefaultBatchSize),
+		baseInsertBatch: paramInt(params, ParamInsertBatchSize, DefaultInsertBatchSize),
+		scale:           1,
+	}
+	b.maxSize = max(paramInt(params, ParamMaxBatchSize, 100000), b.minSize)
+	if b.baseBatch <= 0 {
+		b.baseBatch = DefaultBatchSize
+	}
+	if b.baseInsertBatch <= 0 {
+		b.baseInsertBatch = DefaultInsertBatchSize
+	}
+	b.clamp()
+	return b
+}
+
+// clamp limits the scale so that the BatchSize stays within the minimum
+// and maximum sizes. The mutex must be held by the caller.
+func (b *batchSizer) clamp() {
+	b.scale = min(max(b.scale, float64(b.minSize)/float64(b.baseBatch)), float64(b.maxSize)/float64(b.baseBatch))
+}
+
+// sizes returns the current BatchSize and InsertBatchSize.
+func (b *batchSizer) sizes() (int, int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	batch := int(float64(b.baseBatch) * b.scale)
+	insert := min(max(int(float64(b.baseInsertBatch)*b.scale), 1), b.maxSize)
+	return batch, insert
+}
+
+// observe adjusts the sizes following a batch of rows extracted with the
+// specified BatchSize, which took elapsed to extract, transform and load.
+// Only full batches are considered, as a partial batch indicates that the
+// Iteration has caught up with its source rather than how long a full
+// batch would take. The new sizes are derived from the size of the batch
+// observed, rather than the current sizes, so that batches which were
+// extracted before the last adjustment do not compound it, and are at
+// most half or double that size. It returns whether the sizes have
+// changed.
+func (b *batchSizer) observe(rows, size int, elapsed time.Duration) bool {
+	if rows < size || elapsed <= 0 {
+		return false
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ratio := float64(b.target) / float64(elapsed)
+	if ratio >= 0.8 && ratio <= 1.25 {
+		return false
+	}
+	previous := b.scale
+	b.scale = float64(size) / float64(b.baseBatch) * min(max(ratio, 0.5), 2)
+	b.clamp()
+	return b.scale != previous
+}
+
+// backoff halves the sizes following an error caused by the size of a
+// batch. It returns whether the sizes have changed.
+func (b *batchSizer) backoff() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	previous := b.scale
+	b.scale /= 2
+	b.clamp()
+	return b.scale != previous
+}
+
+// batchSizeError determines whether an error may be avoided by extracting
+// or loading smaller batches: a statement exceeding max_allowed_packet,
+// or a lock wait timeout or lock table exhaustion caused by holding too
+// many row locks.
+func batchSizeError(err error) bool {
+	if errors.Is(err, mysql.ErrPktTooLarge) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1153, // ER_NET_PACKET_TOO_LARGE
+			1205, // ER_LOCK_WAIT_TIMEOUT
+			1206: // ER_LOCK_TABLE_FULL
+			return true
+		}
+	}
+	return false
+}
+
+// initBatchSize creates the batchSizer of an Iteration if adaptive batch
+// sizing is enabled, and reports the initial sizes in its status.
+func (m *Migrator) initBatchSize(x int) {
+	params := *m.Iterations[x].Parameters
+	m.Iterations[x].sizer = nil
+	if paramBool(params, ParamAdaptiveBatchSize, false) {
+		m.Iterations[x].sizer = newBatchSizer(params)
+		m.applyBatchSize(x)
+		return
+	}
+	m.iterationStatus(x).setBatchSizes(paramInt(params, ParamBatchSize, DefaultBatchSize), paramInt(params, ParamInsertBatchSize, DefaultInsertBatchSize))
+}
+
+// applyBatchSize sets the current sizes in the working Parameters of an
+// Iteration, if adaptive batch sizing is enabled, and returns the
+// BatchSize. It must be called from the goroutine which runs the
+// Extractor, as Extractors also modify the working Parameters.
+func (m *Migrator) applyBatchSize(x int) int {
+	sizer := m.Iterations[x].sizer
+	if sizer == nil {
+		return paramInt(*m.Iterations[x].params, ParamBatchSize, DefaultBatchSize)
+	}
+	batch, insert := sizer.sizes()
+	(*m.Iterations[x].params)[ParamBatchSize] = batch
+	(*m.Iterations[x].params)[ParamInsertBatchSize] = insert
+	m.iterationStatus(x).setBatchSizes(batch, insert)
+	return batch
+}
+
+// observeBatchSize adjusts the sizes of an Iteration following a batch
+// which was extracted with the specified BatchSize and took elapsed to
+// process.
+func (m *Migrator) observeBatchSize(x int, rows, size int, elapsed time.Duration) {
+	sizer := m.Iterations[x].sizer
+	if sizer == nil || !sizer.observe(rows, size, elapsed) {
+		return
+	}
+	batch, insert := sizer.sizes()
+	logger.Debugf("Migrator.observeBatchSize(): ["+m.trackingKey(x).String()+"] Batch of %d rows took %s, adjusted sizes to %d / %d", rows, elapsed.String(), batch, insert)
+}
+
+// backoffBatchSize shrinks the sizes of an Iteration if an error may have
+// been caused by the size of its batches.
+func (m *Migrator) backoffBatchSize(x int, err error) {
+	sizer := m.Iterations[x].sizer
+	if sizer == nil || !batchSizeError(err) || !sizer.backoff() {
+		return
+	}
+	batch, insert := sizer.sizes()
+	logger.Warnf("Migrator.backoffBatchSize(): ["+m.trackingKey(x).String()+"] Reduced sizes to %d / %d after: %s", batch, insert, err.Error())
+}
@@ -2,155 +2,461 @@ package migrator
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
-	"math"
-	"reflect"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 // BatchedInsert takes an array of SQL data rows and creates a series of
 // batched inserts to insert the data into an existing sql.Tx (transaction)
 // object.
-func BatchedInsert(tx *sql.Tx, table string, data []SQLUntypedRow, size int, params *Parameters) error {
-	return BatchedQuery(tx, table, data, size, "INSERT", params)
+func BatchedInsert(ctx context.Context, tx *sql.Tx, table string, data []SQLUntypedRow, size int, params *Parameters) error {
+	return BatchedQuery(ctx, tx, table, data, size, "INSERT", params)
 }
 
 // BatchedReplace takes an array of SQL data rows and creates a series of
 // batched replaces to replace the data into an existing sql.Tx (transaction)
 // object.
-func BatchedReplace(tx *sql.Tx, table string, data []SQLUntypedRow, size int, params *Parameters) error {
-	return BatchedQuery(tx, table, data, size, "REPLACE", params)
+func BatchedReplace(ctx context.Context, tx *sql.Tx, table string, data []SQLUntypedRow, size int, params *Parameters) error {
+	return BatchedQuery(ctx, tx, table, data, size, "REPLACE", params)
+}
+
+// BatchedUpsert takes an array of SQL data rows and creates a series of
+// batched INSERT ... ON DUPLICATE KEY UPDATE statements to upsert the data
+// into an existing sql.Tx (transaction) object. Unlike REPLACE, existing
+// rows are updated in place rather than deleted and reinserted. The columns
+// updated are those listed in ParamUpsertColumns, or all columns outside
+// of the primary key of the table if it is not set.
+func BatchedUpsert(ctx context.Context, tx *sql.Tx, table string, data []SQLUntypedRow, size int, params *Parameters) error {
+	return BatchedQuery(ctx, tx, table, data, size, "UPSERT", params)
 }
 
 // BatchedRemove takes an array of SQL data rows and creates a series of
 // DELETE FROM statements to remove the data in an existing sql.Tx (transaction)
-// object.
-func BatchedRemove(tx *sql.Tx, table string, data []SQLUntypedRow, size int, params *Parameters) error {
-	debug := paramBool(*params, ParamDebug, false)
-
-	// Pull column names from first row
+// object. Rows are matched on the primary key of the table, discovered from
+// information_schema once per Migrator, removing up to size rows with each
+// statement, and fewer if their estimated size would exceed
+// ParamMaxAllowedPacket. Rows which do not include the whole primary key,
+// or of a table without one, are removed one at a time, matching all of
+// their columns.
+func BatchedRemove(ctx context.Context, tx *sql.Tx, table string, data []SQLUntypedRow, size int, params *Parameters) error {
 	if len(data) < 1 {
 		return fmt.Errorf("BatchedRemove(): [%s] no data presented", table)
 	}
-	keys := reflect.ValueOf(data[0]).MapKeys()
-	if len(keys) < 1 {
-		return fmt.Errorf("BatchedRemove(): [%s] no columns presented", table)
-	}
 
 	if size < 1 {
 		size = 1
 	}
 
-	for i := range len(data) {
+	primaryKey, err := destinationPrimaryKey(ctx, tx, table, params)
+	if err != nil {
+		logger.Errorf("BatchedRemove(): [%s] ERROR: %s", table, err.Error())
+		return err
+	}
+
+	keyed := make([]SQLUntypedRow, 0, len(data))
+	for i := range data {
+		if len(primaryKey) > 0 && hasColumns(data[i], primaryKey) {
+			keyed = append(keyed, data[i])
+			continue
+		}
+		err = removeRow(ctx, tx, table, data[i], params)
+		if err != nil {
+			return err
+		}
+	}
+	if len(keyed) > 0 {
+		return removeByKey(ctx, tx, table, primaryKey, keyed, size, params)
+	}
+	return nil
+}
+
+// removeByKey removes rows by their primary key, with DELETE statements
+// matching up to size keys each.
+func removeByKey(ctx context.Context, tx *sql.Tx, table string, primaryKey []string, data []SQLUntypedRow, size int, params *Parameters) error {
+	debug := paramBool(*params, ParamDebug, false)
+	lowLevelDebug := paramBool(*params, ParamLowLevelDebug, false)
+	maxPacket := paramInt(*params, ParamMaxAllowedPacket, DefaultMaxAllowedPacket)
+
+	for start := 0; start < len(data); {
 		params := make([]any, 0)
 
-		// Header is always the same
+		// A single column key is matched with a plain IN list
 		prepared := new(bytes.Buffer)
-		prepared.WriteString("DELETE FROM")
-		prepared.WriteString(" `" + table + "` WHERE ")
+		prepared.WriteString("DELETE FROM `" + table + "` WHERE ")
+		if len(primaryKey) == 1 {
+			prepared.WriteString("`" + primaryKey[0] + "` IN (")
+		} else {
+			prepared.WriteString("( ")
+			for i, col := range primaryKey {
+				if i > 0 {
+					prepared.WriteString(", ")
+				}
+				prepared.WriteString("`" + col + "`")
+			}
+			prepared.WriteString(" ) IN (")
+		}
 
-		for iter, k := range keys {
-			if iter != 0 {
-				prepared.WriteString(" AND ")
+		estimated := prepared.Len() + 3
+		end := start
+		for ; end < intmin(start+size, len(data)); end++ {
+			rowSize := estimateRowSize(data[end], primaryKey)
+			if end > start && estimated+rowSize > maxPacket {
+				break
 			}
-			prepared.WriteString("`" + k.String() + "` = ?")
-			params = append(params, data[i][keys[iter].String()])
+			estimated += rowSize
 		}
-		prepared.WriteString(";")
+		for j := start; j < end; j++ {
+			if j > start {
+				prepared.WriteString(",")
+			}
+			if len(primaryKey) == 1 {
+				prepared.WriteString(" ?")
+				params = append(params, data[j][primaryKey[0]])
+				continue
+			}
+			prepared.WriteString(" ( ")
+			for l, col := range primaryKey {
+				if l > 0 {
+					prepared.WriteString(",")
+				}
+				prepared.WriteString("?")
+				params = append(params, data[j][col])
+			}
+			prepared.WriteString(" )")
+		}
+		prepared.WriteString(" );")
 
 		if debug {
-			logger.Debugf("BatchedRemove(): [%s] Prepared remove: %s", table, prepared.String())
+			logger.Debugf("BatchedRemove(): [%s] Prepared remove of %d rows: %s", table, end-start, prepared.String())
 		}
+		start = end
 
 		// Attempt to execute
-		_, err := tx.Exec(prepared.String(), params...)
+		res, err := tx.ExecContext(ctx, prepared.String(), params...)
+		if lowLevelDebug {
+			logger.Tracef("BatchedRemove(): [%s] %s [%#v]", table, prepared.String(), params)
+		}
 		if err != nil {
 			logger.Errorf("BatchedRemove(): [%s] ERROR: %s", table, err.Error())
 			return err
 		}
+		if debug {
+			rowsAffected, _ := res.RowsAffected()
+			logger.Debugf("BatchedRemove(): [%s] rows affected = %d", table, rowsAffected)
+		}
 	}
 
 	return nil
 }
 
-// BatchedQuery takes an array of SQL data rows and creates a series of
-// batched queries to insert/replace the data into an existing sql.Tx
-// (transaction) object.
-func BatchedQuery(tx *sql.Tx, table string, data []SQLUntypedRow, size int, op string, params *Parameters) error {
+// removeRow removes a single row, matching all of its columns in sorted
+// order.
+func removeRow(ctx context.Context, tx *sql.Tx, table string, row SQLUntypedRow, params *Parameters) error {
 	debug := paramBool(*params, ParamDebug, false)
-	lowLevelDebug := paramBool(*params, ParamLowLevelDebug, false)
 
-	// Pull column names from first row
+	columns := rowColumns(row)
+	if len(columns) < 1 {
+		return fmt.Errorf("BatchedRemove(): [%s] no columns presented", table)
+	}
+	args := make([]any, 0, len(columns))
+
+	prepared := new(bytes.Buffer)
+	prepared.WriteString("DELETE FROM")
+	prepared.WriteString(" `" + table + "` WHERE ")
+
+	for iter, col := range columns {
+		if iter != 0 {
+			prepared.WriteString(" AND ")
+		}
+		prepared.WriteString("`" + col + "` = ?")
+		args = append(args, row[col])
+	}
+	prepared.WriteString(";")
+
+	if debug {
+		logger.Debugf("BatchedRemove(): [%s] Prepared remove: %s", table, prepared.String())
+	}
+
+	// Attempt to execute
+	_, err := tx.ExecContext(ctx, prepared.String(), args...)
+	if err != nil {
+		logger.Errorf("BatchedRemove(): [%s] ERROR: %s", table, err.Error())
+		return err
+	}
+	return nil
+}
+
+// BatchedQuery takes an array of SQL data rows and creates a series of
+// batched queries to insert/replace/upsert the data into an existing sql.Tx
+// (transaction) object. Each statement holds up to size rows, and fewer if
+// their estimated size would exceed ParamMaxAllowedPacket. Rows need not
+// have the same columns: consecutive rows with the same columns are loaded
+// together, so that rows are still applied in order, and columns are
+// listed in sorted order.
+func BatchedQuery(ctx context.Context, tx *sql.Tx, table string, data []SQLUntypedRow, size int, op string, params *Parameters) error {
 	if len(data) < 1 {
 		return fmt.Errorf("BatchedQuery(): [%s] no data presented", table)
 	}
-	keys := reflect.ValueOf(data[0]).MapKeys()
-	if len(keys) < 1 {
-		return fmt.Errorf("BatchedQuery(): [%s] no columns presented", table)
-	}
 
 	if size < 1 {
 		size = 1
 	}
-	batches := int(math.Ceil(float64(len(data)) / float64(size)))
 
-	for i := range batches {
+	var upsert *upsertColumns
+	if op == "UPSERT" {
+		var err error
+		upsert, err = resolveUpsertColumns(ctx, tx, table, params)
+		if err != nil {
+			logger.Errorf("BatchedQuery(): [%s] ERROR: %s", table, err.Error())
+			return err
+		}
+	}
+
+	for _, group := range columnGroups(data) {
+		if len(group.columns) < 1 {
+			return fmt.Errorf("BatchedQuery(): [%s] no columns presented", table)
+		}
+		err := batchedQueryGroup(ctx, tx, table, group, size, op, upsert, params)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// batchedQueryGroup runs the batched statements for a group of rows which
+// share the same columns.
+func batchedQueryGroup(ctx context.Context, tx *sql.Tx, table string, group rowGroup, size int, op string, upsert *upsertColumns, params *Parameters) error {
+	debug := paramBool(*params, ParamDebug, false)
+	lowLevelDebug := paramBool(*params, ParamLowLevelDebug, false)
+	maxPacket := paramInt(*params, ParamMaxAllowedPacket, DefaultMaxAllowedPacket)
+	columns, data := group.columns, group.rows
+
+	update := ""
+	if upsert != nil {
+		update = upsert.clause(columns)
+	}
+
+	for start := 0; start < len(data); {
 		params := make([]any, 0)
 
 		// Header is always the same
 		prepared := new(bytes.Buffer)
 		switch op {
-		case "INSERT":
+		case "INSERT", "UPSERT":
 			prepared.WriteString("INSERT INTO")
 		case "REPLACE":
 			prepared.WriteString("REPLACE INTO")
 		default:
 		}
 		prepared.WriteString(" `" + table + "` ( ")
-		for iter, k := range keys {
+		for iter, col := range columns {
 			if iter != 0 {
 				prepared.WriteString(", ")
 			}
-			prepared.WriteString("`" + k.String() + "`")
+			prepared.WriteString("`" + col + "`")
 		}
 		prepared.WriteString(" ) VALUES")
 
-		// Create value clauses
-		for j := i * size; j < intmin((i+1)*size, len(data)); j++ {
-			if j > i*size {
+		// Create value clauses, splitting the batch once the statement
+		// would exceed the packet size. A single row is always sent, and
+		// fails if it is too large by itself.
+		estimated := prepared.Len() + len(update) + 1
+		end := start
+		for ; end < intmin(start+size, len(data)); end++ {
+			rowSize := estimateRowSize(data[end], columns)
+			if end > start && estimated+rowSize > maxPacket {
+				if debug {
+					logger.Debugf("BatchedQuery(): [%s] Splitting batch after %d rows, at about %d bytes", table, end-start, estimated)
+				}
+				break
+			}
+			estimated += rowSize
+		}
+		for j := start; j < end; j++ {
+			if j > start {
 				prepared.WriteString(",")
 			}
 			prepared.WriteString(" ( ")
-			for l := range len(keys) {
+			for l, col := range columns {
 				if l > 0 {
 					prepared.WriteString(",")
 				}
 				prepared.WriteString("?")
-				params = append(params, data[j][keys[l].String()])
+				params = append(params, data[j][col])
 			}
 			prepared.WriteString(" ) ")
 		}
+		prepared.WriteString(update)
 		prepared.WriteString(";")
+		start = end
 
 		if debug {
 			logger.Debugf("BatchedQuery(): [%s] Prepared %s: %s", table, op, prepared.String())
 		}
 
 		// Attempt to execute
-		res, err := tx.Exec(prepared.String(), params...)
+		res, err := tx.ExecContext(ctx, prepared.String(), params...)
 		if lowLevelDebug {
 			logger.Tracef("BatchedQuery(): [%s] %s [%#v]", table, prepared.String(), params)
 		}
+		if err != nil {
+			logger.Errorf("BatchedQuery(): [%s] ERROR: %s", table, err.Error())
+			return err
+		}
 		if debug {
 			lastInsertID, _ := res.LastInsertId()
 			rowsAffected, _ := res.RowsAffected()
 			logger.Debugf("BatchedQuery(): [%s] last id inserted = %d, rows affected = %d", table, lastInsertID, rowsAffected)
 		}
-		if err != nil {
-			logger.Errorf("BatchedQuery(): [%s] ERROR: %s", table, err.Error())
-			return err
-		}
 	}
 
 	return nil
 }
+
+// upsertColumns determines the columns updated by an UPSERT into a table
+// when a row already exists: those listed in ParamUpsertColumns, or each
+// column outside of the primary key of the table ( all columns, if it has
+// none ).
+type upsertColumns struct {
+	columns    []string
+	primaryKey map[string]bool
+}
+
+func resolveUpsertColumns(ctx context.Context, tx *sql.Tx, table string, params *Parameters) (*upsertColumns, error) {
+	u := &upsertColumns{columns: paramStrings(*params, ParamUpsertColumns, nil)}
+	if len(u.columns) > 0 {
+		return u, nil
+	}
+	primaryKey, err := destinationPrimaryKey(ctx, tx, table, params)
+	if err != nil {
+		return nil, err
+	}
+	u.primaryKey = make(map[string]bool, len(primaryKey))
+	for _, col := range primaryKey {
+		u.primaryKey[col] = true
+	}
+	return u, nil
+}
+
+// clause builds the ON DUPLICATE KEY UPDATE clause of an UPSERT of rows
+// with the specified columns, updating those of the columns which are to
+// be updated. If there are none, the first column is assigned to itself
+// so that existing rows are left unchanged.
+func (u *upsertColumns) clause(columns []string) string {
+	updates := make([]string, 0, len(columns))
+	if len(u.columns) > 0 {
+		for _, col := range u.columns {
+			if slices.Contains(columns, col) {
+				updates = append(updates, col)
+			}
+		}
+	} else {
+		for _, col := range columns {
+			if !u.primaryKey[col] {
+				updates = append(updates, col)
+			}
+		}
+	}
+
+	clause := new(bytes.Buffer)
+	clause.WriteString("ON DUPLICATE KEY UPDATE ")
+	for i, col := range updates {
+		if i > 0 {
+			clause.WriteString(", ")
+		}
+		clause.WriteString("`" + col + "` = VALUES(`" + col + "`)")
+	}
+	if len(updates) == 0 {
+		clause.WriteString("`" + columns[0] + "` = `" + columns[0] + "`")
+	}
+	return clause.String()
+}
+
+// rowGroup is a run of consecutive rows which have the same columns.
+type rowGroup struct {
+	columns []string
+	rows    []SQLUntypedRow
+}
+
+// columnGroups splits rows into runs of consecutive rows with the same
+// columns, so that each run can be loaded with the same column list while
+// the rows are still loaded in order.
+func columnGroups(data []SQLUntypedRow) []rowGroup {
+	groups := make([]rowGroup, 0, 1)
+	start := 0
+	for i, row := range data {
+		if n := len(groups); n > 0 && sameColumns(groups[n-1].columns, row) {
+			groups[n-1].rows = data[start : i+1]
+			continue
+		}
+		start = i
+		groups = append(groups, rowGroup{columns: rowColumns(row), rows: data[i : i+1]})
+	}
+	return groups
+}
+
+// rowColumns returns the names of the columns of a row in sorted order.
+func rowColumns(row SQLUntypedRow) []string {
+	return slices.Sorted(maps.Keys(row))
+}
+
+// hasColumns reports whether a row has all of the specified columns.
+func hasColumns(row SQLUntypedRow, columns []string) bool {
+	for _, col := range columns {
+		if _, ok := row[col]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// sameColumns reports whether a row has exactly the specified columns.
+func sameColumns(columns []string, row SQLUntypedRow) bool {
+	return len(columns) == len(row) && hasColumns(row, columns)
+}
+
+// estimateRowSize estimates the number of bytes a row adds to a batched
+// statement. Strings and binary values are counted twice, as escaping
+// them may double their size when parameters are interpolated, and other
+// values are counted at their largest text representation.
+func estimateRowSize(row SQLUntypedRow, columns []string) int {
+	size := 5 + 2*len(columns)
+	for _, col := range columns {
+		switch v := row[col].(type) {
+		case []byte:
+			size += 2*len(v) + 2
+		case string:
+			size += 2*len(v) + 2
+		case nil:
+			size += 4
+		case time.Time:
+			size += 28
+		default:
+			size += 24
+		}
+	}
+	return size
+}
+
+// destinationMaxPacket determines the largest statement which can be sent
+// to a destination database: the max_allowed_packet of the server, or of
+// the driver if it is configured to be smaller.
+func destinationMaxPacket(db *sql.DB, dsn *mysql.Config) (int, error) {
+	var maxPacket int
+	err := db.QueryRow("SELECT @@max_allowed_packet").Scan(&maxPacket)
+	if err != nil {
+		return 0, err
+	}
+	if dsn != nil && dsn.MaxAllowedPacket > 0 && dsn.MaxAllowedPacket < maxPacket {
+		maxPacket = dsn.MaxAllowedPacket
+	}
+	return maxPacket, nil
+}
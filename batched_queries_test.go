@@ -0,0 +1,379 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	l := log.New()
+	l.SetOutput(io.Discard)
+	SetLogger(l)
+	os.Exit(m.Run())
+}
+
+// recorder is a database/sql driver which records the statements executed
+// against it, answering primary key lookups with primaryKey.
+type recorder struct {
+	primaryKey []string
+	stmts      []string
+	args       [][]driver.Value
+}
+
+func newRecordingDB(t *testing.T, primaryKey ...string) (*sql.DB, *recorder) {
+	r := &recorder{primaryKey: primaryKey}
+	db := sql.OpenDB(r)
+	t.Cleanup(func() { db.Close() })
+	return db, r
+}
+
+func (r *recorder) Connect(context.Context) (driver.Conn, error) { return recorderConn{r}, nil }
+func (r *recorder) Driver() driver.Driver                        { return nil }
+
+type recorderConn struct{ r *recorder }
+
+func (c recorderConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c recorderConn) Close() error                        { return nil }
+func (c recorderConn) Begin() (driver.Tx, error)           { return c, nil }
+func (c recorderConn) Commit() error                       { return nil }
+func (c recorderConn) Rollback() error                     { return nil }
+
+func (c recorderConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values := make([]driver.Value, len(args))
+	for i := range args {
+		values[i] = args[i].Value
+	}
+	c.r.stmts = append(c.r.stmts, query)
+	c.r.args = append(c.r.args, values)
+	return driver.RowsAffected(1), nil
+}
+
+func (c recorderConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows := &recorderRows{}
+	if strings.Contains(query, "information_schema.STATISTICS") {
+		for _, col := range c.r.primaryKey {
+			rows.values = append(rows.values, col)
+		}
+	}
+	return rows, nil
+}
+
+type recorderRows struct {
+	values []string
+}
+
+func (r *recorderRows) Columns() []string { return []string{"COLUMN_NAME"} }
+func (r *recorderRows) Close() error      { return nil }
+func (r *recorderRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = r.values[0], r.values[1:]
+	return nil
+}
+
+// recordBatch runs a batched statement function within a transaction on a
+// recording database, returning the statements and arguments it executed.
+func recordBatch(t *testing.T, primaryKey []string, run func(tx *sql.Tx) error) ([]string, [][]driver.Value) {
+	db, r := newRecordingDB(t, primaryKey...)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err = run(tx); err != nil {
+		t.Fatal(err)
+	}
+	return r.stmts, r.args
+}
+
+func TestRowColumns(t *testing.T) {
+	tests := []struct {
+		row  SQLUntypedRow
+		want []string
+	}{
+		{SQLUntypedRow{}, nil},
+		{SQLUntypedRow{"id": 1}, []string{"id"}},
+		{SQLUntypedRow{"c": 1, "a": nil, "b": "x"}, []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		if got := rowColumns(tt.row); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("rowColumns(%v): got %v, expected %v", tt.row, got, tt.want)
+		}
+	}
+}
+
+func TestColumnGroups(t *testing.T) {
+	a1 := SQLUntypedRow{"id": 1, "a": "x"}
+	a2 := SQLUntypedRow{"a": "y", "id": 2}
+	b1 := SQLUntypedRow{"id": 3, "b": "z"}
+	c1 := SQLUntypedRow{"id": 4}
+	tests := []struct {
+		name string
+		data []SQLUntypedRow
+		want []rowGroup
+	}{
+		{"empty", []SQLUntypedRow{}, []rowGroup{}},
+		{"single shape", []SQLUntypedRow{a1, a2}, []rowGroup{
+			{[]string{"a", "id"}, []SQLUntypedRow{a1, a2}},
+		}},
+		{"shape changes", []SQLUntypedRow{a1, b1, b1}, []rowGroup{
+			{[]string{"a", "id"}, []SQLUntypedRow{a1}},
+			{[]string{"b", "id"}, []SQLUntypedRow{b1, b1}},
+		}},
+		{"shapes are not merged out of order", []SQLUntypedRow{a1, b1, a2}, []rowGroup{
+			{[]string{"a", "id"}, []SQLUntypedRow{a1}},
+			{[]string{"b", "id"}, []SQLUntypedRow{b1}},
+			{[]string{"a", "id"}, []SQLUntypedRow{a2}},
+		}},
+		{"subset of columns", []SQLUntypedRow{a1, c1, a2}, []rowGroup{
+			{[]string{"a", "id"}, []SQLUntypedRow{a1}},
+			{[]string{"id"}, []SQLUntypedRow{c1}},
+			{[]string{"a", "id"}, []SQLUntypedRow{a2}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := columnGroups(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateRowSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		row     SQLUntypedRow
+		columns []string
+		want    int
+	}{
+		{"no columns", SQLUntypedRow{}, []string{}, 5},
+		{"string", SQLUntypedRow{"a": "abc"}, []string{"a"}, 5 + 2 + 2*3 + 2},
+		{"binary", SQLUntypedRow{"a": []byte{0, 1}}, []string{"a"}, 5 + 2 + 2*2 + 2},
+		{"null", SQLUntypedRow{"a": nil}, []string{"a"}, 5 + 2 + 4},
+		{"missing column", SQLUntypedRow{}, []string{"a"}, 5 + 2 + 4},
+		{"time", SQLUntypedRow{"a": time.Now()}, []string{"a"}, 5 + 2 + 28},
+		{"integer", SQLUntypedRow{"a": int64(-1)}, []string{"a"}, 5 + 2 + 24},
+		{"only listed columns", SQLUntypedRow{"a": 1, "b": "long value"}, []string{"a"}, 5 + 2 + 24},
+		{"several columns", SQLUntypedRow{"a": 1, "b": "xy"}, []string{"a", "b"}, 5 + 4 + 24 + 2*2 + 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimateRowSize(tt.row, tt.columns); got != tt.want {
+				t.Errorf("got %d, expected %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchedQuery(t *testing.T) {
+	row := func(id int) SQLUntypedRow {
+		return SQLUntypedRow{"id": id, "name": strings.Repeat("x", 100)}
+	}
+	rows := func(n int) []SQLUntypedRow {
+		out := make([]SQLUntypedRow, n)
+		for i := range out {
+			out[i] = row(i)
+		}
+		return out
+	}
+	insert := "INSERT INTO `t` ( `id`, `name` ) VALUES"
+	// Each row of rows() is estimated at 5 + 4 + 24 + 202 bytes
+	rowSize := estimateRowSize(row(0), []string{"id", "name"})
+	header := len(insert) + 1
+
+	tests := []struct {
+		name       string
+		op         string
+		data       []SQLUntypedRow
+		size       int
+		params     Parameters
+		primaryKey []string
+		want       []string
+		wantArgs   []int
+	}{
+		{
+			name: "insert",
+			op:   "INSERT",
+			data: rows(2),
+			size: 10,
+			want: []string{insert + " ( ?,? ) , ( ?,? ) ;"},
+		},
+		{
+			name: "replace",
+			op:   "REPLACE",
+			data: rows(1),
+			size: 10,
+			want: []string{"REPLACE INTO `t` ( `id`, `name` ) VALUES ( ?,? ) ;"},
+		},
+		{
+			name:       "upsert outside of the primary key",
+			op:         "UPSERT",
+			data:       rows(1),
+			size:       10,
+			primaryKey: []string{"id"},
+			want:       []string{insert + " ( ?,? ) ON DUPLICATE KEY UPDATE `name` = VALUES(`name`);"},
+		},
+		{
+			name:       "upsert of listed columns",
+			op:         "UPSERT",
+			data:       rows(1),
+			size:       10,
+			params:     Parameters{ParamUpsertColumns: []string{"id", "missing"}},
+			primaryKey: []string{"id"},
+			want:       []string{insert + " ( ?,? ) ON DUPLICATE KEY UPDATE `id` = VALUES(`id`);"},
+		},
+		{
+			name:       "upsert of primary key columns only",
+			op:         "UPSERT",
+			data:       []SQLUntypedRow{{"id": 1}},
+			size:       10,
+			primaryKey: []string{"id"},
+			want:       []string{"INSERT INTO `t` ( `id` ) VALUES ( ? ) ON DUPLICATE KEY UPDATE `id` = `id`;"},
+		},
+		{
+			name: "mixed row shapes",
+			op:   "INSERT",
+			data: []SQLUntypedRow{row(1), {"id": 2}, row(3)},
+			size: 10,
+			want: []string{
+				insert + " ( ?,? ) ;",
+				"INSERT INTO `t` ( `id` ) VALUES ( ? ) ;",
+				insert + " ( ?,? ) ;",
+			},
+		},
+		{
+			name:     "split by size",
+			op:       "INSERT",
+			data:     rows(5),
+			size:     2,
+			wantArgs: []int{4, 4, 2},
+		},
+		{
+			name:     "split at the packet boundary",
+			op:       "INSERT",
+			data:     rows(5),
+			size:     10,
+			params:   Parameters{ParamMaxAllowedPacket: header + 2*rowSize},
+			wantArgs: []int{4, 4, 2},
+		},
+		{
+			name:     "split just below the packet boundary",
+			op:       "INSERT",
+			data:     rows(5),
+			size:     10,
+			params:   Parameters{ParamMaxAllowedPacket: header + 2*rowSize - 1},
+			wantArgs: []int{2, 2, 2, 2, 2},
+		},
+		{
+			name:     "oversized rows are sent alone",
+			op:       "INSERT",
+			data:     rows(3),
+			size:     10,
+			params:   Parameters{ParamMaxAllowedPacket: 10},
+			wantArgs: []int{2, 2, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := tt.params
+			if params == nil {
+				params = Parameters{}
+			}
+			stmts, args := recordBatch(t, tt.primaryKey, func(tx *sql.Tx) error {
+				return BatchedQuery(context.Background(), tx, "t", tt.data, tt.size, tt.op, &params)
+			})
+			if tt.want != nil && !reflect.DeepEqual(stmts, tt.want) {
+				t.Errorf("statements: got %q, expected %q", stmts, tt.want)
+			}
+			if tt.wantArgs != nil {
+				got := make([]int, len(args))
+				for i := range args {
+					got[i] = len(args[i])
+				}
+				if !reflect.DeepEqual(got, tt.wantArgs) {
+					t.Errorf("arguments per statement: got %v, expected %v", got, tt.wantArgs)
+				}
+			}
+			// Rows are loaded in order
+			ids := make([]driver.Value, 0)
+			for i := range args {
+				for _, v := range args[i] {
+					if _, ok := v.(int64); ok {
+						ids = append(ids, v)
+					}
+				}
+			}
+			for i := 1; i < len(ids); i++ {
+				if ids[i].(int64) < ids[i-1].(int64) {
+					t.Errorf("rows loaded out of order: %v", ids)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestBatchedRemove(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []SQLUntypedRow
+		primaryKey []string
+		want       []string
+		wantArgs   [][]driver.Value
+	}{
+		{
+			name:       "single column key",
+			data:       []SQLUntypedRow{{"id": 1, "a": "x"}, {"id": 2, "a": "y"}},
+			primaryKey: []string{"id"},
+			want:       []string{"DELETE FROM `t` WHERE `id` IN ( ?, ? );"},
+			wantArgs:   [][]driver.Value{{int64(1), int64(2)}},
+		},
+		{
+			name:       "composite key",
+			data:       []SQLUntypedRow{{"a": 1, "b": "x", "c": 0}, {"a": 2, "b": "y", "c": 0}},
+			primaryKey: []string{"b", "a"},
+			want:       []string{"DELETE FROM `t` WHERE ( `b`, `a` ) IN ( ( ?,? ), ( ?,? ) );"},
+			wantArgs:   [][]driver.Value{{"x", int64(1), "y", int64(2)}},
+		},
+		{
+			name:       "rows without the key",
+			data:       []SQLUntypedRow{{"id": 1}, {"b": "x", "a": nil}},
+			primaryKey: []string{"id"},
+			want: []string{
+				"DELETE FROM `t` WHERE `a` = ? AND `b` = ?;",
+				"DELETE FROM `t` WHERE `id` IN ( ? );",
+			},
+			wantArgs: [][]driver.Value{{nil, "x"}, {int64(1)}},
+		},
+		{
+			name:     "table without a key",
+			data:     []SQLUntypedRow{{"id": 1, "a": "x"}},
+			want:     []string{"DELETE FROM `t` WHERE `a` = ? AND `id` = ?;"},
+			wantArgs: [][]driver.Value{{"x", int64(1)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmts, args := recordBatch(t, tt.primaryKey, func(tx *sql.Tx) error {
+				return BatchedRemove(context.Background(), tx, "t", tt.data, 10, &Parameters{})
+			})
+			if !reflect.DeepEqual(stmts, tt.want) {
+				t.Errorf("statements: got %q, expected %q", stmts, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("arguments: got %v, expected %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jbuchbinder/migrator"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// apiMigrator is the representation of a migrator returned by the admin
+// API.
+type apiMigrator struct {
+	Index       int            `json:"index"`
+	Source      string         `json:"source"`
+	Destination string         `json:"destination"`
+	State       string         `json:"state"`
+	Iterations  []apiIteration `json:"iterations"`
+}
+
+// apiIteration is the representation of an iteration returned by the admin
+// API.
+type apiIteration struct {
+	ID               string                   `json:"id,omitempty"`
+	SourceTable      string                   `json:"source-table"`
+	DestinationTable string                   `json:"destination-table"`
+	Extractor        string                   `json:"extractor"`
+	Loader           string                   `json:"loader"`
+	State            string                   `json:"state"`
+	Paused           bool                     `json:"paused"`
+	LastError        string                   `json:"last-error,omitempty"`
+	LastErrorTime    *time.Time               `json:"last-error-time,omitempty"`
+	LastBatchSize    int                      `json:"last-batch-size"`
+	BatchSize        int                      `json:"batch-size"`
+	InsertBatchSize  int                      `json:"insert-batch-size"`
+	LastRun          *time.Time               `json:"last-run,omitempty"`
+	TrackingStatus   *migrator.TrackingStatus `json:"tracking-status,omitempty"`
+}
+
+// apiLag is the representation of the lag of an iteration returned by the
+// admin API.
+type apiLag struct {
+	Extractor     string    `json:"extractor"`
+	Keys          int64     `json:"keys"`
+	Rows          int64     `json:"rows"`
+	QueueDepth    int64     `json:"queue-depth"`
+	BinlogBytes   int64     `json:"binlog-bytes"`
+	BehindSeconds float64   `json:"behind-seconds"`
+	CheckedAt     time.Time `json:"checked-at"`
+}
+
+// adminAPI serves the HTTP admin API for a set of running migrators.
+type adminAPI struct {
+	migrators []*migrator.Migrator
+	logger    *log.Logger
+}
+
+// startAdminAPI starts the HTTP admin API, including the Prometheus
+// /metrics endpoint, on the specified address and port, returning the
+// server so that it can be shut down. The API is not authenticated, so
+// it should only be served on an address which untrusted clients cannot
+// reach; an empty address serves it on all interfaces.
+func startAdminAPI(address string, port int, migrators []*migrator.Migrator, logger *log.Logger) *http.Server {
+	api := &adminAPI{migrators: migrators, logger: logger}
+
+	mux := http.NewServeMux()
+	api.routes(mux)
+
+	server := &http.Server{
+		Addr:              net.JoinHostPort(address, strconv.Itoa(port)),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		logger.Printf("Serving admin API on %s", server.Addr)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Printf("ERROR: admin API: %s", err.Error())
+		}
+	}()
+	return server
+}
+
+// stopAdminAPI gracefully shuts down the HTTP admin API.
+func stopAdminAPI(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+}
+
+func (a *adminAPI) routes(mux *http.ServeMux) {
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /migrators", a.listMigrators)
+	mux.HandleFunc("GET /migrators/{migrator}", a.getMigrator)
+	mux.HandleFunc("POST /migrators/{migrator}/pause", a.migratorAction(func(m *migrator.Migrator) error { return m.Pause() }))
+	mux.HandleFunc("POST /migrators/{migrator}/unpause", a.migratorAction(func(m *migrator.Migrator) error { return m.Unpause() }))
+	mux.HandleFunc("POST /migrators/{migrator}/quit", a.migratorAction(func(m *migrator.Migrator) error { return m.Quit() }))
+	mux.HandleFunc("GET /migrators/{migrator}/iterations/{iteration}", a.getIteration)
+	mux.HandleFunc("GET /migrators/{migrator}/iterations/{iteration}/lag", a.getLag)
+	mux.HandleFunc("POST /migrators/{migrator}/iterations/{iteration}/pause", a.iterationAction((*migrator.Migrator).PauseIteration))
+	mux.HandleFunc("POST /migrators/{migrator}/iterations/{iteration}/unpause", a.iterationAction((*migrator.Migrator).UnpauseIteration))
+	mux.HandleFunc("POST /migrators/{migrator}/iterations/{iteration}/run", a.iterationAction((*migrator.Migrator).TriggerIteration))
+	mux.HandleFunc("POST /migrators/{migrator}/iterations/{iteration}/rewind", a.rewindIteration)
+}
+
+// migrator resolves the {migrator} path value, which is the index of the
+// migrator in the configuration file.
+func (a *adminAPI) migrator(w http.ResponseWriter, r *http.Request) (int, bool) {
+	i, err := strconv.Atoi(r.PathValue("migrator"))
+	if err != nil || i < 0 || i >= len(a.migrators) || a.migrators[i] == nil {
+		a.error(w, http.StatusNotFound, fmt.Errorf("no migrator '%s'", r.PathValue("migrator")))
+		return 0, false
+	}
+	return i, true
+}
+
+func (a *adminAPI) listMigrators(w http.ResponseWriter, r *http.Request) {
+	out := make([]apiMigrator, 0, len(a.migrators))
+	for i := range a.migrators {
+		if a.migrators[i] == nil {
+			continue
+		}
+		out = append(out, a.describeMigrator(i))
+	}
+	a.json(w, http.StatusOK, out)
+}
+
+func (a *adminAPI) getMigrator(w http.ResponseWriter, r *http.Request) {
+	i, ok := a.migrator(w, r)
+	if !ok {
+		return
+	}
+	a.json(w, http.StatusOK, a.describeMigrator(i))
+}
+
+func (a *adminAPI) getIteration(w http.ResponseWriter, r *http.Request) {
+	i, ok := a.migrator(w, r)
+	if !ok {
+		return
+	}
+	m := a.migrators[i]
+	status, err := m.IterationStatus(r.PathValue("iteration"))
+	if err != nil {
+		a.error(w, http.StatusNotFound, err)
+		return
+	}
+	for x := range m.Iterations {
+		if m.Iterations[x].SourceTable == status.SourceTable && m.Iterations[x].DestinationTable == status.DestinationTable && m.Iterations[x].ID == status.ID {
+			a.json(w, http.StatusOK, a.describeIteration(m, x, status))
+			return
+		}
+	}
+	a.error(w, http.StatusNotFound, fmt.Errorf("no iteration '%s'", r.PathValue("iteration")))
+}
+
+// getLag calculates the lag of an iteration behind its source table, which
+// queries the source database.
+func (a *adminAPI) getLag(w http.ResponseWriter, r *http.Request) {
+	i, ok := a.migrator(w, r)
+	if !ok {
+		return
+	}
+	lag, err := a.migrators[i].Lag(r.PathValue("iteration"))
+	if err != nil {
+		a.error(w, http.StatusNotFound, err)
+		return
+	}
+	a.json(w, http.StatusOK, apiLag{
+		Extractor:     lag.Extractor,
+		Keys:          lag.Keys,
+		Rows:          lag.Rows,
+		QueueDepth:    lag.QueueDepth,
+		BinlogBytes:   lag.BinlogBytes,
+		BehindSeconds: lag.Behind.Seconds(),
+		CheckedAt:     lag.CheckedAt,
+	})
+}
+
+func (a *adminAPI) migratorAction(action func(*migrator.Migrator) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		i, ok := a.migrator(w, r)
+		if !ok {
+			return
+		}
+		err := action(a.migrators[i])
+		if err != nil {
+			a.error(w, http.StatusConflict, err)
+			return
+		}
+		a.json(w, http.StatusOK, a.describeMigrator(i))
+	}
+}
+
+func (a *adminAPI) iterationAction(action func(*migrator.Migrator, string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		i, ok := a.migrator(w, r)
+		if !ok {
+			return
+		}
+		err := action(a.migrators[i], r.PathValue("iteration"))
+		if err != nil {
+			a.error(w, http.StatusNotFound, err)
+			return
+		}
+		a.getIteration(w, r)
+	}
+}
+
+// rewindIteration moves the tracking position of an iteration to the
+// position fields of the TrackingStatus in the request body, for example:
+//
+//	{ "sequential-position": 1000 }
+func (a *adminAPI) rewindIteration(w http.ResponseWriter, r *http.Request) {
+	i, ok := a.migrator(w, r)
+	if !ok {
+		return
+	}
+	var position migrator.TrackingStatus
+	err := json.NewDecoder(r.Body).Decode(&position)
+	if err != nil {
+		a.error(w, http.StatusBadRequest, err)
+		return
+	}
+	err = a.migrators[i].RewindIteration(r.PathValue("iteration"), position)
+	if err != nil {
+		a.error(w, http.StatusNotFound, err)
+		return
+	}
+	a.json(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+}
+
+func (a *adminAPI) describeMigrator(i int) apiMigrator {
+	m := a.migrators[i]
+	out := apiMigrator{
+		Index:       i,
+		Source:      m.SourceDsn.Addr + "/" + m.SourceDsn.DBName,
+		Destination: m.DestinationDsn.Addr + "/" + m.DestinationDsn.DBName,
+		State:       m.State().String(),
+		Iterations:  make([]apiIteration, 0, len(m.Iterations)),
+	}
+	statuses := m.IterationStatuses()
+	for x := range m.Iterations {
+		out.Iterations = append(out.Iterations, a.describeIteration(m, x, statuses[x]))
+	}
+	return out
+}
+
+// describeIteration reads only the fields of an iteration which do not
+// change while it is running.
+func (a *adminAPI) describeIteration(m *migrator.Migrator, x int, status migrator.IterationStatus) apiIteration {
+	out := apiIteration{
+		ID:               status.ID,
+		SourceTable:      status.SourceTable,
+		DestinationTable: status.DestinationTable,
+		Extractor:        m.Iterations[x].ExtractorName,
+		Loader:           m.Iterations[x].LoaderName,
+		State:            status.State.String(),
+		Paused:           status.Paused,
+		LastBatchSize:    status.LastBatchSize,
+		BatchSize:        status.BatchSize,
+		InsertBatchSize:  status.InsertBatchSize,
+	}
+	if status.LastError != nil {
+		out.LastError = status.LastError.Error()
+		out.LastErrorTime = &status.LastErrorTime
+	}
+	if !status.LastRun.IsZero() {
+		out.LastRun = &status.LastRun
+	}
+	ts, err := m.GetTrackingStatus(migrator.Iteration{
+		ID:               status.ID,
+		SourceTable:      status.SourceTable,
+		DestinationTable: status.DestinationTable,
+	})
+	if err == nil {
+		out.TrackingStatus = &ts
+	}
+	return out
+}
+
+func (a *adminAPI) json(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		a.logger.Printf("ERROR: admin API: %s", err.Error())
+	}
+}
+
+func (a *adminAPI) error(w http.ResponseWriter, code int, err error) {
+	a.json(w, code, map[string]string{"error": err.Error()})
+}
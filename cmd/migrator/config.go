@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 
 	"github.com/jbuchbinder/migrator"
@@ -12,23 +13,47 @@ import (
 type MigratorConfig struct {
 	Debug             bool         `yaml:"debug"`
 	Port              int          `yaml:"port"`
+	ListenAddress     string       `yaml:"listen-address"`
 	Migrations        []Migrations `yaml:"migrations"`
 	TrackingTableName string       `yaml:"tracking-table"`
+	TrackingStore     string       `yaml:"tracking-store"`
+	TrackingStorePath string       `yaml:"tracking-store-path"`
 	Parameters        struct {
-		BatchSize         int  `yaml:"batch-size"`
-		InsertBatchSize   int  `yaml:"insert-batch-size"`
-		SequentialReplace bool `yaml:"sequential-replace"`
-		SleepBetweenRuns  int  `yaml:"sleep-between-runs"`
+		BatchSize               int    `yaml:"batch-size"`
+		InsertBatchSize         int    `yaml:"insert-batch-size"`
+		SequentialReplace       bool   `yaml:"sequential-replace"`
+		SleepBetweenRuns        int    `yaml:"sleep-between-runs"`
+		DeadLetterPath          string `yaml:"dead-letter-path"`
+		DeadLetterRetryInterval int    `yaml:"dead-letter-retry-interval"`
+		LagCheckInterval        int    `yaml:"lag-check-interval"`
+		VerifyChunkSize         int    `yaml:"verify-chunk-size"`
+		SnapshotThreads         int    `yaml:"snapshot-threads"`
+		SnapshotChunkSize       int    `yaml:"snapshot-chunk-size"`
+		BackfillThreads         int    `yaml:"backfill-threads"`
+		BackfillRanges          int    `yaml:"backfill-ranges"`
+		PipelineDepth           int    `yaml:"pipeline-depth"`
+		BatchTargetDuration     int    `yaml:"batch-target-duration"`
+		MinBatchSize            int    `yaml:"min-batch-size"`
+		MaxBatchSize            int    `yaml:"max-batch-size"`
+		ThrottleThreadsRunning  int    `yaml:"throttle-threads-running"`
+		ThrottleReplicaLag      int    `yaml:"throttle-replica-lag"`
+		ThrottleCheckInterval   int    `yaml:"throttle-check-interval"`
+		RetryDelay              int    `yaml:"retry-delay"`
+		RetryMaxDelay           int    `yaml:"retry-max-delay"`
+		CircuitBreakerThreshold int    `yaml:"circuit-breaker-threshold"`
 	} `yaml:"parameters"`
 	Timeout int `yaml:"timeout"`
 }
 
 // Migrations represents a single migration configuration instance.
 type Migrations struct {
-	SourceDsn  string `yaml:"source-dsn"`
-	TargetDsn  string `yaml:"target-dsn"`
-	Apm        bool   `yaml:"apm"`
-	Iterations []struct {
+	SourceDsn        string `yaml:"source-dsn"`
+	TargetDsn        string `yaml:"target-dsn"`
+	Apm              bool   `yaml:"apm"`
+	RowsPerSecond    int    `yaml:"rows-per-second"`
+	QueriesPerSecond int    `yaml:"queries-per-second"`
+	Iterations       []struct {
+		ID     string `yaml:"id"`
 		Source struct {
 			Table string `yaml:"table"`
 			Key   string `yaml:"key"`
@@ -38,7 +63,18 @@ type Migrations struct {
 		} `yaml:"target"`
 		Extractor             string               `yaml:"extractor"`
 		Transformer           string               `yaml:"transformer"`
+		Loader                string               `yaml:"loader"`
 		TransformerParameters *migrator.Parameters `yaml:"transformer-parameters"`
+		LagThreshold          int                  `yaml:"lag-threshold"`
+		LagRowThreshold       int                  `yaml:"lag-row-threshold"`
+		Snapshot              bool                 `yaml:"snapshot"`
+		Backfill              bool                 `yaml:"backfill"`
+		Pipeline              bool                 `yaml:"pipeline"`
+		AdaptiveBatchSize     bool                 `yaml:"adaptive-batch-size"`
+		RowsPerSecond         int                  `yaml:"rows-per-second"`
+		QueriesPerSecond      int                  `yaml:"queries-per-second"`
+		LoadMethod            string               `yaml:"load-method"`
+		UpsertColumns         []string             `yaml:"upsert-columns"`
 	} `yaml:"iterations"`
 }
 
@@ -47,8 +83,108 @@ type Migrations struct {
 func (c *MigratorConfig) SetDefaults() {
 	c.Debug = false
 	c.Port = 3040
+	c.ListenAddress = "127.0.0.1"
 	c.TrackingTableName = "Tracking"
+	c.TrackingStore = "mysql"
 	c.Timeout = 0
+	c.Parameters.DeadLetterRetryInterval = 60
+	c.Parameters.LagCheckInterval = 60
+	c.Parameters.VerifyChunkSize = 1000
+	c.Parameters.SnapshotThreads = 4
+	c.Parameters.SnapshotChunkSize = 10000
+	c.Parameters.BackfillThreads = 4
+	c.Parameters.BackfillRanges = 16
+	c.Parameters.PipelineDepth = 2
+	c.Parameters.BatchTargetDuration = 1000
+	c.Parameters.MinBatchSize = 10
+	c.Parameters.MaxBatchSize = 100000
+	c.Parameters.ThrottleCheckInterval = 1
+	c.Parameters.RetryDelay = 1000
+	c.Parameters.RetryMaxDelay = 60000
+}
+
+// MigratorParameters creates a new set of migrator Parameters from the
+// global parameters in the current MigratorConfig instance.
+func (c *MigratorConfig) MigratorParameters() *migrator.Parameters {
+	return &migrator.Parameters{
+		migrator.ParamDebug:                   c.Debug,
+		migrator.ParamBatchSize:               c.Parameters.BatchSize,
+		migrator.ParamInsertBatchSize:         c.Parameters.InsertBatchSize,
+		migrator.ParamSequentialReplace:       c.Parameters.SequentialReplace,
+		migrator.ParamSleepBetweenRuns:        c.Parameters.SleepBetweenRuns,
+		migrator.ParamDeadLetterPath:          c.Parameters.DeadLetterPath,
+		migrator.ParamDeadLetterRetryInterval: c.Parameters.DeadLetterRetryInterval,
+		migrator.ParamLagCheckInterval:        c.Parameters.LagCheckInterval,
+		migrator.ParamVerifyChunkSize:         c.Parameters.VerifyChunkSize,
+		migrator.ParamSnapshotThreads:         c.Parameters.SnapshotThreads,
+		migrator.ParamSnapshotChunkSize:       c.Parameters.SnapshotChunkSize,
+		migrator.ParamBackfillThreads:         c.Parameters.BackfillThreads,
+		migrator.ParamBackfillRanges:          c.Parameters.BackfillRanges,
+		migrator.ParamPipelineDepth:           c.Parameters.PipelineDepth,
+		migrator.ParamBatchTargetDuration:     c.Parameters.BatchTargetDuration,
+		migrator.ParamMinBatchSize:            c.Parameters.MinBatchSize,
+		migrator.ParamMaxBatchSize:            c.Parameters.MaxBatchSize,
+		migrator.ParamThrottleThreadsRunning:  c.Parameters.ThrottleThreadsRunning,
+		migrator.ParamThrottleReplicaLag:      c.Parameters.ThrottleReplicaLag,
+		migrator.ParamThrottleCheckInterval:   c.Parameters.ThrottleCheckInterval,
+		migrator.ParamRetryDelay:              c.Parameters.RetryDelay,
+		migrator.ParamRetryMaxDelay:           c.Parameters.RetryMaxDelay,
+		migrator.ParamCircuitBreakerThreshold: c.Parameters.CircuitBreakerThreshold,
+	}
+}
+
+// IterationParameters creates the Parameters for an iteration, identified
+// by the index of its migration and its index within that migration, from
+// the global parameters and the settings of the iteration.
+func (c *MigratorConfig) IterationParameters(i, j int) *migrator.Parameters {
+	iter := c.Migrations[i].Iterations[j]
+	parameters := c.MigratorParameters()
+	(*parameters)[migrator.ParamLagThreshold] = iter.LagThreshold
+	(*parameters)[migrator.ParamLagRowThreshold] = iter.LagRowThreshold
+	(*parameters)[migrator.ParamSnapshot] = iter.Snapshot
+	(*parameters)[migrator.ParamBackfill] = iter.Backfill
+	(*parameters)[migrator.ParamPipeline] = iter.Pipeline
+	(*parameters)[migrator.ParamAdaptiveBatchSize] = iter.AdaptiveBatchSize
+	(*parameters)[migrator.ParamRowsPerSecond] = iter.RowsPerSecond
+	(*parameters)[migrator.ParamQueriesPerSecond] = iter.QueriesPerSecond
+	(*parameters)[migrator.ParamLoadMethod] = iter.LoadMethod
+	if len(iter.UpsertColumns) > 0 {
+		(*parameters)[migrator.ParamUpsertColumns] = iter.UpsertColumns
+	}
+	return parameters
+}
+
+// IterationLoader returns the name of the loader for an iteration,
+// identified by the index of its migration and its index within that
+// migration, which defaults to "default".
+func (c *MigratorConfig) IterationLoader(i, j int) string {
+	if c.Migrations[i].Iterations[j].Loader == "" {
+		return "default"
+	}
+	return c.Migrations[i].Iterations[j].Loader
+}
+
+// NewTrackingStore creates the migrator.TrackingStore specified by the
+// current MigratorConfig instance, which is shared by all migrators. A nil
+// store indicates that each migrator should use the tracking table in its
+// destination database.
+func (c *MigratorConfig) NewTrackingStore() (migrator.TrackingStore, error) {
+	switch c.TrackingStore {
+	case "", "mysql":
+		return nil, nil
+	case "memory":
+		return migrator.NewMemoryTrackingStore(), nil
+	case "file", "leveldb":
+		if c.TrackingStorePath == "" {
+			return nil, fmt.Errorf("tracking-store %s requires tracking-store-path", c.TrackingStore)
+		}
+		if c.TrackingStore == "file" {
+			return migrator.NewFileTrackingStore(c.TrackingStorePath), nil
+		}
+		return migrator.NewLevelDBTrackingStore(c.TrackingStorePath), nil
+	default:
+		return nil, fmt.Errorf("unknown tracking-store '%s'", c.TrackingStore)
+	}
 }
 
 // LoadConfigWithDefaults loads a YAML configuration file representing a
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jbuchbinder/migrator"
+	log "github.com/sirupsen/logrus"
+)
+
+// deadLetterCommand implements the "deadletter" command, which lists,
+// replays or purges the batches held in the dead letter queues of the
+// configured iterations. An optional source table name restricts the
+// command to a single iteration. This must not be run while the migrator
+// is running, as the queues are locked by the running process.
+func deadLetterCommand(config *MigratorConfig, logger *log.Logger, args []string) error {
+	if config.Parameters.DeadLetterPath == "" {
+		return errors.New("no dead-letter-path configured")
+	}
+	if len(args) < 1 {
+		return errors.New("usage: migrator deadletter list|replay|purge [table]")
+	}
+	action := args[0]
+	table := ""
+	if len(args) > 1 {
+		table = args[1]
+	}
+	switch action {
+	case "list", "replay", "purge":
+	default:
+		return fmt.Errorf("unknown action '%s'", action)
+	}
+
+	for i := range config.Migrations {
+		src, err := mysql.ParseDSN(config.Migrations[i].SourceDsn)
+		if err != nil {
+			return err
+		}
+
+		var dest *mysql.Config
+		var destDb *sql.DB
+		for j := range config.Migrations[i].Iterations {
+			sourceTable := config.Migrations[i].Iterations[j].Source.Table
+			if table != "" && sourceTable != table {
+				continue
+			}
+
+			pq, err := migrator.OpenDeadLetterQueue(config.Parameters.DeadLetterPath, migrator.TrackingKey{
+				SourceDatabase:   src.DBName,
+				SourceTable:      sourceTable,
+				DestinationTable: config.Migrations[i].Iterations[j].Target.Table,
+				IterationID:      config.Migrations[i].Iterations[j].ID,
+			})
+			if err != nil {
+				return err
+			}
+
+			switch action {
+			case "list":
+				var dls []migrator.DeadLetter
+				dls, err = migrator.ListDeadLetters(&pq)
+				for n, dl := range dls {
+					fmt.Printf("%s.%s #%d: %d rows for %s at %s: %s\n", dl.SourceDatabase, dl.SourceTable, n, dl.RowCount(), dl.DestinationTable, dl.Timestamp.Format("2006-01-02 15:04:05"), dl.Error)
+				}
+
+			case "replay":
+				// Batches are replayed as the iteration would load them
+				loaderName := config.IterationLoader(i, j)
+				loader, ok := migrator.LoaderMap[loaderName]
+				if !ok {
+					pq.Close()
+					return fmt.Errorf("unknown loader '%s'", loaderName)
+				}
+				if destDb == nil {
+					dest, err = mysql.ParseDSN(config.Migrations[i].TargetDsn)
+					if err != nil {
+						pq.Close()
+						return err
+					}
+					dest.ParseTime = true
+					destDb, err = sql.Open("mysql", dest.FormatDSN())
+					if err != nil {
+						pq.Close()
+						return err
+					}
+					defer destDb.Close()
+				}
+				var count int
+				params := config.IterationParameters(i, j)
+				(*params)[migrator.ParamDestinationDsn] = dest
+				count, err = migrator.ReplayDeadLetters(context.Background(), destDb, &pq, loader, params)
+				logger.Printf("Replayed %d batches for %s.%s", count, src.DBName, sourceTable)
+
+			case "purge":
+				var count int
+				count, err = pq.Purge()
+				logger.Printf("Purged %d batches for %s.%s", count, src.DBName, sourceTable)
+			}
+			pq.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
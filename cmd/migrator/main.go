@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
@@ -10,6 +12,7 @@ import (
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/jbuchbinder/migrator"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -27,109 +30,94 @@ func main() {
 		panic(err)
 	}
 
-	stop := make(chan os.Signal)
-	signal.Notify(stop, syscall.SIGTERM)
-	signal.Notify(stop, syscall.SIGINT)
-
 	migrator.TrackingTableName = config.TrackingTableName
 	migrator.SetLogger(logger)
 
+	switch flag.Arg(0) {
+	case "", "run":
+		run(config, logger)
+	case "deadletter":
+		err = deadLetterCommand(config, logger, flag.Args()[1:])
+		if err != nil {
+			logger.Fatalf("deadletter: %s", err.Error())
+		}
+	case "verify":
+		err = verifyCommand(config, logger, flag.Args()[1:], false)
+		if err != nil {
+			logger.Fatalf("verify: %s", err.Error())
+		}
+	case "repair":
+		err = verifyCommand(config, logger, flag.Args()[1:], true)
+		if err != nil {
+			logger.Fatalf("repair: %s", err.Error())
+		}
+	default:
+		logger.Fatalf("Unknown command '%s'", flag.Arg(0))
+	}
+}
+
+// run starts all configured migrators and waits for them to be stopped.
+func run(config *MigratorConfig, logger *log.Logger) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if config.Timeout != 0 {
+		logger.Printf("Running for %d seconds", config.Timeout)
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(config.Timeout)*time.Second)
+	}
+	defer cancel()
+
 	var wg sync.WaitGroup
 
+	store, err := config.NewTrackingStore()
+	if err != nil {
+		panic(err)
+	}
+
 	migrators := make([]*migrator.Migrator, len(config.Migrations))
+	metrics := newPrometheusMetrics(prometheus.DefaultRegisterer)
 
 	for i := 0; i < len(config.Migrations); i++ {
-		src, _ := mysql.ParseDSN(config.Migrations[i].SourceDsn)
-		dest, _ := mysql.ParseDSN(config.Migrations[i].TargetDsn)
-
-		migrators[i] = &migrator.Migrator{
-			SourceDsn:      src,
-			DestinationDsn: dest,
-			Apm:            config.Migrations[i].Apm,
-			Iterations:     []migrator.Iteration{},
-			Parameters: &migrator.Parameters{
-				migrator.ParamDebug:             config.Debug,
-				migrator.ParamBatchSize:         config.Parameters.BatchSize,
-				migrator.ParamInsertBatchSize:   config.Parameters.InsertBatchSize,
-				migrator.ParamSequentialReplace: config.Parameters.SequentialReplace,
-				migrator.ParamSleepBetweenRuns:  config.Parameters.SleepBetweenRuns,
-			},
-		}
+		migrators[i] = newMigrator(config, i, store, logger)
+		migrators[i].Metrics = metrics
 		migrators[i].SetWaitGroup(&wg)
-
-		for j := range config.Migrations[i].Iterations {
-			if _, ok := migrator.ExtractorMap[config.Migrations[i].Iterations[j].Extractor]; !ok {
-				logger.Printf("'%s' is not a valid type of extractor [%#v]", config.Migrations[i].Iterations[j].Extractor, config.Migrations[i].Iterations[j])
-				continue
-			}
-
-			parameters := &migrator.Parameters{
-				migrator.ParamDebug:             config.Debug,
-				migrator.ParamBatchSize:         config.Parameters.BatchSize,
-				migrator.ParamInsertBatchSize:   config.Parameters.InsertBatchSize,
-				migrator.ParamSequentialReplace: config.Parameters.SequentialReplace,
-				migrator.ParamSleepBetweenRuns:  config.Parameters.SleepBetweenRuns,
-			}
-
-			transformer := config.Migrations[i].Iterations[j].Transformer
-			if transformer == "" {
-				transformer = "default"
-			}
-
-			if _, ok := migrator.TransformerMap[transformer]; !ok {
-				logger.Printf("Unable to resolve transformer '%s' for %#v", transformer, config.Migrations[i])
-				panic("bailing out")
-			}
-
-			transformerParameters := config.Migrations[i].Iterations[j].TransformerParameters
-			if transformerParameters == nil {
-				transformerParameters = parameters
-			}
-
-			logger.Printf("Initializing with transformer parameters #%v", transformerParameters)
-			iter := migrator.Iteration{
-				SourceTable:           config.Migrations[i].Iterations[j].Source.Table,
-				SourceKey:             config.Migrations[i].Iterations[j].Source.Key,
-				DestinationTable:      config.Migrations[i].Iterations[j].Target.Table,
-				Parameters:            parameters,
-				Extractor:             migrator.ExtractorMap[config.Migrations[i].Iterations[j].Extractor],
-				ExtractorName:         config.Migrations[i].Iterations[j].Extractor,
-				Transformer:           migrator.TransformerMap[transformer],
-				TransformerParameters: transformerParameters,
-				Loader:                migrator.DefaultLoader,
-				LoaderName:            "default",
-			}
-			migrators[i].Iterations = append(migrators[i].Iterations, iter)
-		}
 		err := migrators[i].Init()
 		if err != nil {
 			panic(err)
 		}
-		defer migrators[i].Close()
 	}
 
 	for i := range migrators {
 		logger.Printf("Starting migrator #%d", i)
-		err = migrators[i].Run()
+		err = migrators[i].Run(ctx)
 		if err != nil {
 			log.Print(err)
 			continue
 		}
 	}
 
-	if config.Timeout != 0 {
-		logger.Printf("Sleeping for %d seconds waiting for runs to finish", config.Timeout)
-		time.Sleep(time.Duration(config.Timeout) * time.Second)
-
-		for i := range migrators {
-			migrators[i].Quit()
-		}
-		return
+	var server *http.Server
+	if config.Port > 0 {
+		server = startAdminAPI(config.ListenAddress, config.Port, migrators, logger)
 	}
 
+	// Migrators stop by themselves if they encounter a fatal error
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
 	logger.Printf("Waiting for stop signals")
-	sig := <-stop
-	logger.Printf("caught sig: %+v", sig)
+	select {
+	case sig := <-stop:
+		logger.Printf("caught sig: %+v", sig)
+	case <-ctx.Done():
+		logger.Printf("Timeout of %d seconds reached", config.Timeout)
+	case <-finished:
+		logger.Printf("All migrators have stopped")
+	}
 	logger.Printf("Signalling all migrators to stop")
 	for i := range migrators {
 		err := migrators[i].Quit()
@@ -139,5 +127,85 @@ func main() {
 	}
 	logger.Printf("Wait for all threads to finish processing")
 	wg.Wait()
-	os.Exit(0)
+	if server != nil {
+		stopAdminAPI(server)
+	}
+
+	status := 0
+	for i := range migrators {
+		err := migrators[i].Wait()
+		if err != nil {
+			logger.Printf("ERROR: migrator #%d: %s", i, err.Error())
+			status = 1
+		}
+	}
+	if store != nil {
+		store.Close()
+	}
+	os.Exit(status)
+}
+
+// newMigrator creates the migrator for the configured migration with the
+// specified index, without initializing it.
+func newMigrator(config *MigratorConfig, i int, store migrator.TrackingStore, logger *log.Logger) *migrator.Migrator {
+	src, _ := mysql.ParseDSN(config.Migrations[i].SourceDsn)
+	dest, _ := mysql.ParseDSN(config.Migrations[i].TargetDsn)
+
+	m := &migrator.Migrator{
+		SourceDsn:      src,
+		DestinationDsn: dest,
+		Apm:            config.Migrations[i].Apm,
+		Iterations:     []migrator.Iteration{},
+		Parameters:     config.MigratorParameters(),
+		TrackingStore:  store,
+	}
+	(*m.Parameters)[migrator.ParamRowsPerSecond] = config.Migrations[i].RowsPerSecond
+	(*m.Parameters)[migrator.ParamQueriesPerSecond] = config.Migrations[i].QueriesPerSecond
+
+	for j := range config.Migrations[i].Iterations {
+		if _, ok := migrator.ExtractorMap[config.Migrations[i].Iterations[j].Extractor]; !ok {
+			logger.Printf("'%s' is not a valid type of extractor [%#v]", config.Migrations[i].Iterations[j].Extractor, config.Migrations[i].Iterations[j])
+			continue
+		}
+
+		parameters := config.IterationParameters(i, j)
+
+		transformer := config.Migrations[i].Iterations[j].Transformer
+		if transformer == "" {
+			transformer = "default"
+		}
+
+		if _, ok := migrator.TransformerMap[transformer]; !ok {
+			logger.Printf("Unable to resolve transformer '%s' for %#v", transformer, config.Migrations[i])
+			panic("bailing out")
+		}
+
+		loader := config.IterationLoader(i, j)
+		if _, ok := migrator.LoaderMap[loader]; !ok {
+			logger.Printf("Unable to resolve loader '%s' for %#v", loader, config.Migrations[i])
+			panic("bailing out")
+		}
+
+		transformerParameters := config.Migrations[i].Iterations[j].TransformerParameters
+		if transformerParameters == nil {
+			transformerParameters = parameters
+		}
+
+		logger.Printf("Initializing with transformer parameters #%v", transformerParameters)
+		iter := migrator.Iteration{
+			ID:                    config.Migrations[i].Iterations[j].ID,
+			SourceTable:           config.Migrations[i].Iterations[j].Source.Table,
+			SourceKey:             config.Migrations[i].Iterations[j].Source.Key,
+			DestinationTable:      config.Migrations[i].Iterations[j].Target.Table,
+			Parameters:            parameters,
+			Extractor:             migrator.ExtractorMap[config.Migrations[i].Iterations[j].Extractor],
+			ExtractorName:         config.Migrations[i].Iterations[j].Extractor,
+			Transformer:           migrator.TransformerMap[transformer],
+			TransformerParameters: transformerParameters,
+			Loader:                migrator.LoaderMap[loader],
+			LoaderName:            loader,
+		}
+		m.Iterations = append(m.Iterations, iter)
+	}
+	return m
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jbuchbinder/migrator"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// iterationLabels are the labels identifying an iteration in all metrics.
+var iterationLabels = []string{"source_database", "source_table", "destination_table", "iteration"}
+
+// prometheusMetrics is a migrator.MetricsCollector which exposes
+// per-iteration metrics to Prometheus.
+type prometheusMetrics struct {
+	rows     *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+
+	lagDesc       *prometheus.Desc
+	lastRunDesc   *prometheus.Desc
+	positionMutex sync.Mutex
+	positions     map[migrator.TrackingKey]migrator.TrackingStatus
+}
+
+// newPrometheusMetrics creates a prometheusMetrics collector and registers
+// it with the specified registerer.
+func newPrometheusMetrics(reg prometheus.Registerer) *prometheusMetrics {
+	p := &prometheusMetrics{
+		rows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "migrator_rows_total",
+			Help: "Number of rows processed by stage ( Extractor, Loader ) and method ( INSERT, REPLACE, REMOVE ).",
+		}, append(append([]string{}, iterationLabels...), "stage", "method")),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "migrator_stage_duration_seconds",
+			Help:    "Time taken by each stage of a batch.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, append(append([]string{}, iterationLabels...), "stage")),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "migrator_errors_total",
+			Help: "Number of errors encountered by stage.",
+		}, append(append([]string{}, iterationLabels...), "stage")),
+		lagDesc: prometheus.NewDesc(
+			"migrator_lag_seconds",
+			"Time since the timestamp position of the iteration, for timestamp based extractors.",
+			iterationLabels, nil,
+		),
+		lastRunDesc: prometheus.NewDesc(
+			"migrator_last_run_age_seconds",
+			"Time since the tracking position of the iteration last advanced.",
+			iterationLabels, nil,
+		),
+		positions: map[migrator.TrackingKey]migrator.TrackingStatus{},
+	}
+	reg.MustRegister(p.rows, p.duration, p.errors, p)
+	return p
+}
+
+func labelValues(key migrator.TrackingKey, extra ...string) []string {
+	return append([]string{key.SourceDatabase, key.SourceTable, key.DestinationTable, key.IterationID}, extra...)
+}
+
+// ObserveStage implements migrator.MetricsCollector.
+func (p *prometheusMetrics) ObserveStage(key migrator.TrackingKey, stage string, duration time.Duration, err error) {
+	p.duration.WithLabelValues(labelValues(key, stage)...).Observe(duration.Seconds())
+	if err != nil {
+		p.errors.WithLabelValues(labelValues(key, stage)...).Inc()
+	}
+}
+
+// ObserveRows implements migrator.MetricsCollector.
+func (p *prometheusMetrics) ObserveRows(key migrator.TrackingKey, stage string, method string, count int) {
+	p.rows.WithLabelValues(labelValues(key, stage, method)...).Add(float64(count))
+}
+
+// ObservePosition implements migrator.MetricsCollector.
+func (p *prometheusMetrics) ObservePosition(key migrator.TrackingKey, ts migrator.TrackingStatus) {
+	p.positionMutex.Lock()
+	defer p.positionMutex.Unlock()
+	p.positions[key] = ts
+}
+
+// Describe implements prometheus.Collector for the lag metrics, which are
+// calculated when scraped.
+func (p *prometheusMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.lagDesc
+	ch <- p.lastRunDesc
+}
+
+// Collect implements prometheus.Collector for the lag metrics.
+func (p *prometheusMetrics) Collect(ch chan<- prometheus.Metric) {
+	p.positionMutex.Lock()
+	defer p.positionMutex.Unlock()
+	now := time.Now()
+	for key, ts := range p.positions {
+		if ts.TimestampPosition.Valid {
+			ch <- prometheus.MustNewConstMetric(p.lagDesc, prometheus.GaugeValue, now.Sub(ts.TimestampPosition.Time).Seconds(), labelValues(key)...)
+		}
+		if ts.LastRun.Valid {
+			ch <- prometheus.MustNewConstMetric(p.lastRunDesc, prometheus.GaugeValue, now.Sub(ts.LastRun.Time).Seconds(), labelValues(key)...)
+		}
+	}
+}
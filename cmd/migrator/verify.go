@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jbuchbinder/migrator"
+	log "github.com/sirupsen/logrus"
+)
+
+// verifyCommand implements the "verify" command, which compares the source
+// and destination tables of the configured iterations by row count and by
+// checksums over ranges of primary keys, and reports the ranges which do
+// not match. An optional iteration ID or source table name restricts the
+// command to a single iteration. An error is returned if any table does
+// not match. If repair is set, this implements the "repair" command, which
+// also reloads the ranges which do not match.
+func verifyCommand(config *MigratorConfig, logger *log.Logger, args []string, repair bool) error {
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	store, err := config.NewTrackingStore()
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		defer store.Close()
+	}
+
+	ctx := context.Background()
+	found := false
+	failed := 0
+	for i := range config.Migrations {
+		m := newMigrator(config, i, store, logger)
+		for x := range m.Iterations {
+			// Dead letter queues are locked by a running migrator, and are
+			// not needed to verify
+			delete(*m.Iterations[x].Parameters, migrator.ParamDeadLetterPath)
+		}
+
+		if name != "" && !hasIteration(m, name) {
+			continue
+		}
+
+		var results []migrator.RepairResult
+		err = m.Init()
+		if err == nil {
+			results, err = verifyMigrator(ctx, m, name, repair)
+		}
+		m.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, r := range results {
+			found = true
+			status := "OK"
+			if !r.Matched() {
+				status = "MISMATCH"
+				if repair {
+					status = fmt.Sprintf("REPAIRED ( replaced %d, removed %d rows )", r.Replaced, r.Removed)
+				} else {
+					failed++
+				}
+			}
+			fmt.Printf("%s.%s -> %s.%s: %s, %d / %d rows in %d chunks", m.SourceDsn.DBName, r.SourceTable, m.DestinationDsn.DBName, r.DestinationTable, status, r.SourceRows, r.DestinationRows, r.Chunks)
+			if r.Position != "" {
+				fmt.Printf(" ( %s )", r.Position)
+			}
+			fmt.Println()
+			for _, mismatch := range r.Mismatches {
+				fmt.Printf("  mismatch  %s\n", mismatch.String())
+			}
+			for _, pending := range r.InFlight {
+				fmt.Printf("  in flight %s\n", pending.String())
+			}
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("no iteration named '%s'", name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d tables did not match", failed)
+	}
+	return nil
+}
+
+// verifyMigrator verifies, or repairs, all iterations of an initialized
+// migrator or the single iteration with the specified name.
+func verifyMigrator(ctx context.Context, m *migrator.Migrator, name string, repair bool) ([]migrator.RepairResult, error) {
+	switch {
+	case repair && name == "":
+		return m.RepairAll(ctx)
+	case repair:
+		result, err := m.Repair(ctx, name)
+		return []migrator.RepairResult{result}, err
+	case name == "":
+		verified, err := m.VerifyAll(ctx)
+		out := make([]migrator.RepairResult, len(verified))
+		for i := range verified {
+			out[i].VerifyResult = verified[i]
+		}
+		return out, err
+	default:
+		verified, err := m.Verify(ctx, name)
+		return []migrator.RepairResult{{VerifyResult: verified}}, err
+	}
+}
+
+// hasIteration determines whether a migrator has an iteration with the
+// specified ID or source table name.
+func hasIteration(m *migrator.Migrator, name string) bool {
+	for _, iter := range m.Iterations {
+		if iter.ID == name || iter.SourceTable == name {
+			return true
+		}
+	}
+	return false
+}
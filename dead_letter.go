@@ -0,0 +1,189 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/beeker1121/goque"
+	"github.com/go-sql-driver/mysql"
+)
+
+func init() {
+	// Types which can appear in untyped rows and are not registered with
+	// encoding/gob by default
+	gob.Register(time.Time{})
+	gob.Register(NullTime{})
+}
+
+// DeadLetter represents a batch of transformed data which could not be
+// loaded into the destination database, stored so that it can be retried
+// once the problem has been resolved.
+type DeadLetter struct {
+	SourceDatabase   string
+	SourceTable      string
+	DestinationTable string
+	Timestamp        time.Time
+	Error            string
+	Data             []TableData
+}
+
+// RowCount returns the number of rows contained in the dead lettered batch.
+func (d DeadLetter) RowCount() int {
+	count := 0
+	for _, t := range d.Data {
+		count += len(t.Data)
+	}
+	return count
+}
+
+// OpenDeadLetterQueue opens the on-disk dead letter queue for the Iteration
+// identified by a TrackingKey, located beneath the specified base path.
+func OpenDeadLetterQueue(basePath string, key TrackingKey) (PersistenceQueue, error) {
+	return OpenQueue(filepath.Join(basePath, key.String()))
+}
+
+// ListDeadLetters retrieves all batches currently held in a dead letter
+// queue without removing them.
+func ListDeadLetters(pq *PersistenceQueue) ([]DeadLetter, error) {
+	out := make([]DeadLetter, 0)
+	length := pq.Length()
+	for i := uint64(0); i < length; i++ {
+		var dl DeadLetter
+		err := pq.PeekItem(i, &dl)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, dl)
+	}
+	return out, nil
+}
+
+// ReplayDeadLetters attempts to load all batches held in a dead letter
+// queue, in order, using the specified Loader. Batches are only removed
+// from the queue once they have been successfully loaded, and replaying
+// stops at the first failure. If the Parameters do not specify
+// ParamMaxAllowedPacket, it is determined from the destination database
+// ( limited by ParamDestinationDsn, if present ), as Init() does, and the
+// primary keys of destination tables are looked up once for all batches.
+// The number of batches replayed is returned.
+func ReplayDeadLetters(ctx context.Context, db *sql.DB, pq *PersistenceQueue, loader Loader, params *Parameters) (int, error) {
+	tag := "ReplayDeadLetters(): "
+	if _, ok := (*params)[ParamMaxAllowedPacket]; !ok {
+		dsn, _ := (*params)[ParamDestinationDsn].(*mysql.Config)
+		maxPacket, err := destinationMaxPacket(db, dsn)
+		if err != nil {
+			logger.Warnf(tag+"Unable to determine max_allowed_packet, assuming %d bytes: %s", DefaultMaxAllowedPacket, err.Error())
+			maxPacket = DefaultMaxAllowedPacket
+		}
+		params = cloneParams(params)
+		(*params)[ParamMaxAllowedPacket] = maxPacket
+	}
+	if _, ok := (*params)[paramPrimaryKeys]; !ok {
+		params = cloneParams(params)
+		(*params)[paramPrimaryKeys] = newPrimaryKeyCache()
+	}
+
+	count := 0
+	for {
+		var dl DeadLetter
+		err := pq.GrabItem(&dl, func(item any) error {
+			d := item.(*DeadLetter)
+			// Dead lettered batches do not update the tracking position
+			return loader(ctx, db, d.Data, TrackingStatus{}, params)
+		})
+		if errors.Is(err, goque.ErrEmpty) || errors.Is(err, goque.ErrOutOfBounds) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// storeDeadLetter writes a batch which failed to load into the dead letter
+// queue for an iteration and persists the new tracking position, so that
+// extraction can continue past the failed batch. It returns false if dead
+// lettering is not enabled or the batch could not be stored, in which case
+// the previous tracking position should be retained.
+func (m *Migrator) storeDeadLetter(x int, data []TableData, ts TrackingStatus, loadErr error) bool {
+	tag := "Migrator.storeDeadLetter(): [" + m.SourceDsn.DBName + "." + m.Iterations[x].SourceTable + "] "
+
+	pq := m.Iterations[x].deadLetter
+	if pq == nil {
+		return false
+	}
+
+	dl := DeadLetter{
+		SourceDatabase:   m.SourceDsn.DBName,
+		SourceTable:      m.Iterations[x].SourceTable,
+		DestinationTable: m.Iterations[x].DestinationTable,
+		Timestamp:        time.Now(),
+		Error:            loadErr.Error(),
+		Data:             data,
+	}
+	if dl.RowCount() == 0 {
+		return false
+	}
+
+	err := pq.AddItem(dl)
+	if err != nil {
+		logger.Errorf(tag+"Unable to store batch: %s", err.Error())
+		return false
+	}
+	logger.Warnf(tag+"Stored batch of %d rows in dead letter queue", dl.RowCount())
+
+	err = m.trackingStore.Update(ts)
+	if err != nil {
+		// The position will be persisted with the next successful batch
+		logger.Errorf(tag+"Tracking: %s", err.Error())
+	}
+	return true
+}
+
+// retryDeadLetters periodically replays the dead letter queue for an
+// iteration until the context is cancelled.
+func (m *Migrator) retryDeadLetters(ctx context.Context, x int) {
+	tag := "Migrator.retryDeadLetters(): [" + m.SourceDsn.DBName + "." + m.Iterations[x].SourceTable + "] "
+	interval := time.Duration(paramInt(*m.Iterations[x].Parameters, ParamDeadLetterRetryInterval, 60)) * time.Second
+
+	for {
+		if !sleepWithInterrupt(ctx, interval) {
+			return
+		}
+		if m.State() == S_PAUSED {
+			continue
+		}
+
+		pq := m.Iterations[x].deadLetter
+		if pq == nil || pq.Length() == 0 {
+			continue
+		}
+
+		start := time.Now()
+		count, err := ReplayDeadLetters(ctx, m.destinationDb, pq, m.Iterations[x].Loader, m.Iterations[x].Parameters)
+		if count > 0 {
+			logger.Infof(tag+"Replayed %d dead lettered batches", count)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		m.observeStage(x, StageDeadLetter, start, err)
+		if err != nil {
+			logger.Warnf(tag+"Replay: %s", err.Error())
+			if m.ErrorCallback != nil {
+				m.ErrorCallback(map[string]string{
+					"Stage":            StageDeadLetter,
+					"SourceDb":         m.SourceDsn.DBName,
+					"SourceTable":      m.Iterations[x].SourceTable,
+					"DestinationDb":    m.DestinationDsn.DBName,
+					"DestinationTable": m.Iterations[x].DestinationTable,
+				}, err)
+			}
+		}
+	}
+}
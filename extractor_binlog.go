@@ -0,0 +1,717 @@
+package migrator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func init() {
+	ExtractorMap["binlog"] = ExtractorBinlog
+}
+
+var (
+	// binlogStreams holds the running mysqlbinlog processes, keyed by
+	// source server, database and table, so that a stream survives
+	// between extractor runs.
+	binlogStreams      = map[string]*binlogStream{}
+	binlogStreamsMutex = &sync.Mutex{}
+
+	binlogEndLogPosRegex = regexp.MustCompile(`end_log_pos (\d+)`)
+	binlogRotateRegex    = regexp.MustCompile(`Rotate to (\S+)\s+pos: (\d+)`)
+	binlogGtidNextRegex  = regexp.MustCompile(`GTID_NEXT= '([^']+)'`)
+	binlogRowRegex       = regexp.MustCompile("^### (INSERT INTO|UPDATE|DELETE FROM) `([^`]+)`\\.`([^`]+)`")
+	binlogColumnRegex    = regexp.MustCompile(`^###   @(\d+)=(.*)$`)
+	binlogUnsignedRegex  = regexp.MustCompile(`^-?\d+ \((\d+)\)$`)
+)
+
+// ExtractorBinlog is an Extractor instance which tails the row-based binary
+// log of the source server using mysqlbinlog, emitting INSERT, REPLACE and
+// REMOVE rows for the table being extracted. The binary log file and
+// position ( and optionally the executed GTID set ) of the last complete
+// transaction are kept in the TrackingStatus.
+var ExtractorBinlog = func(ctx context.Context, db *sql.DB, dbName, tableName string, ts TrackingStatus, params *Parameters) (bool, []SQLRow, TrackingStatus, error) {
+	batchSize := paramInt(*params, ParamBatchSize, DefaultBatchSize)
+	timeout := paramInt(*params, ParamTimeout, 5)
+	debug := paramBool(*params, ParamDebug, false)
+
+	tag := fmt.Sprintf("ExtractorBinlog[%s.%s]: ", dbName, tableName)
+
+	if debug {
+		logger.Debugf(tag+"Beginning run with params %#v", params)
+	}
+
+	data := make([]SQLRow, 0)
+
+	dsn, ok := (*params)[ParamSourceDsn].(*mysql.Config)
+	if !ok || dsn == nil {
+		err := errors.New("no source DSN present in parameters")
+		logger.Errorf(tag+"ERR: %s", err.Error())
+		return false, data, ts, err
+	}
+
+	tsStart := time.Now()
+
+	stream, err := getBinlogStream(ctx, db, dsn, dbName, tableName, ts, params)
+	if err != nil {
+		logger.Errorf(tag+"ERR: %s", err.Error())
+		return false, data, ts, err
+	}
+
+	timer := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer timer.Stop()
+
+READ:
+	for len(stream.committed) < batchSize {
+		select {
+		case line, ok := <-stream.lines:
+			if !ok {
+				err = stream.wait()
+				closeBinlogStream(stream.key)
+				logger.Errorf(tag+"mysqlbinlog exited: %s", err.Error())
+				return false, data, ts, err
+			}
+			err = stream.parse(line)
+			if err != nil {
+				closeBinlogStream(stream.key)
+				logger.Errorf(tag+"Parse: %s", err.Error())
+				return false, data, ts, err
+			}
+		case <-timer.C:
+			break READ
+		case <-ctx.Done():
+			// Rows already parsed remain in the stream for the next run
+			return false, data, ts, ctx.Err()
+		}
+	}
+
+	data = append(data, stream.committed...)
+	stream.committed = stream.committed[:0]
+	dataCount := len(data)
+
+	logger.Infof(tag+"Duration to extract %d rows: %s", dataCount, time.Since(tsStart).String())
+
+	moreData := dataCount >= batchSize
+	if debug {
+		logger.Debugf(tag+"Batch size %d, row count %d; more data = %t", batchSize, dataCount, moreData)
+		logger.Debugf(tag+"Binlog position %s:%d", stream.file, stream.pos)
+	}
+
+	// Copy old object ...
+	newTs := &TrackingStatus{
+		Db:                 ts.Db,
+		SourceDatabase:     ts.SourceDatabase,
+		SourceTable:        ts.SourceTable,
+		DestinationTable:   ts.DestinationTable,
+		IterationID:        ts.IterationID,
+		ColumnName:         ts.ColumnName,
+		SequentialPosition: ts.SequentialPosition,
+		TimestampPosition:  ts.TimestampPosition,
+		// ... with updates
+		BinlogFile:     stream.file,
+		BinlogPosition: stream.pos,
+		GtidSet:        stream.gtid.String(),
+		LastRun:        NullTimeFromTime(tsStart),
+	}
+
+	(*params)[ParamMethod] = "REPLACE"
+
+	return moreData, data, *newTs, nil
+}
+
+// binlogColumn describes a column of the table being tailed, as row events
+// only identify columns by their ordinal position.
+type binlogColumn struct {
+	Name     string
+	DataType string
+	Unsigned bool
+}
+
+// binlogStream wraps a running mysqlbinlog process and the state of the
+// parser which converts its verbose output into rows.
+type binlogStream struct {
+	key       string
+	dbName    string
+	tableName string
+	keys      []string
+	columns   []binlogColumn
+	replace   bool
+	useGtid   bool
+
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	lines  chan string
+	done   chan struct{}
+
+	// file and pos are the position after the last complete transaction
+	// which has been parsed, and gtid the executed GTID set at that point.
+	file string
+	pos  int64
+	gtid binlogGtidSet
+
+	// committed holds rows from complete transactions which have not yet
+	// been handed to the caller.
+	committed []SQLRow
+
+	currentFile string
+	endLogPos   int64
+	pendingGtid string
+	pending     []SQLRow
+	row         *SQLRow
+	rowMatches  bool
+	rowSection  string
+	wantSection string
+}
+
+// getBinlogStream returns the running stream for a table, (re)starting
+// mysqlbinlog if no stream exists or if the stream is not positioned where
+// the tracking status says it should be.
+func getBinlogStream(ctx context.Context, db *sql.DB, dsn *mysql.Config, dbName, tableName string, ts TrackingStatus, params *Parameters) (*binlogStream, error) {
+	// Iterations reading the same table each consume their own stream
+	key := dsn.Addr + "/" + ts.Key().String()
+
+	binlogStreamsMutex.Lock()
+	stream, ok := binlogStreams[key]
+	binlogStreamsMutex.Unlock()
+	if ok {
+		if ts.BinlogFile == "" || (stream.file == ts.BinlogFile && stream.pos == ts.BinlogPosition) {
+			return stream, nil
+		}
+		logger.Infof("getBinlogStream(): [%s] Restarting stream at %s:%d, was at %s:%d", key, ts.BinlogFile, ts.BinlogPosition, stream.file, stream.pos)
+		closeBinlogStream(key)
+	}
+
+	stream = &binlogStream{
+		key:       key,
+		dbName:    dbName,
+		tableName: tableName,
+		replace:   paramBool(*params, ParamSequentialReplace, false),
+		useGtid:   paramBool(*params, ParamBinlogGtid, false),
+		file:      ts.BinlogFile,
+		pos:       ts.BinlogPosition,
+		lines:     make(chan string, 1024),
+		done:      make(chan struct{}),
+		stderr:    new(bytes.Buffer),
+	}
+	if ts.ColumnName != "" {
+		stream.keys = strings.Split(ts.ColumnName, ",")
+	}
+
+	var err error
+	if stream.useGtid {
+		stream.gtid, err = parseBinlogGtidSet(ts.GtidSet)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if stream.file == "" {
+		var executed string
+		stream.file, stream.pos, executed, err = binlogMasterStatus(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		if stream.useGtid {
+			stream.gtid, err = parseBinlogGtidSet(executed)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	stream.currentFile = stream.file
+
+	stream.columns, err = binlogTableColumns(ctx, db, dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	if len(stream.columns) < 1 {
+		return nil, fmt.Errorf("unable to find columns for %s.%s", dbName, tableName)
+	}
+
+	serverID := paramInt(*params, ParamBinlogServerID, 0)
+	if serverID == 0 {
+		serverID = 1000 + int(crc32.ChecksumIEEE([]byte(key))%1000000)
+	}
+
+	args := []string{
+		"--read-from-remote-server",
+		"--user=" + dsn.User,
+		"--stop-never",
+		"--connection-server-id=" + strconv.Itoa(serverID),
+		"--base64-output=DECODE-ROWS",
+		"--verbose",
+		"--verbose",
+		"--database=" + dbName,
+		"--start-position=" + strconv.FormatInt(stream.pos, 10),
+	}
+	if dsn.Net == "unix" {
+		args = append(args, "--socket="+dsn.Addr)
+	} else {
+		host, port, found := strings.Cut(dsn.Addr, ":")
+		args = append(args, "--host="+host)
+		if found {
+			args = append(args, "--port="+port)
+		}
+	}
+	if stream.useGtid && !stream.gtid.Empty() {
+		args = append(args, "--exclude-gtids="+stream.gtid.String())
+	}
+	args = append(args, stream.file)
+
+	stream.cmd = exec.Command(paramString(*params, ParamBinlogCommand, "mysqlbinlog"), args...)
+	// Pass the password through the environment to keep it out of the
+	// process list
+	stream.cmd.Env = append(os.Environ(), "MYSQL_PWD="+dsn.Passwd)
+	stream.cmd.Stderr = stream.stderr
+	stdout, err := stream.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("getBinlogStream(): [%s] Starting stream at %s:%d", key, stream.file, stream.pos)
+	err = stream.cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer close(stream.lines)
+		scanner := bufio.NewScanner(stdout)
+		// Rows containing large BLOB / TEXT values are printed on one line
+		scanner.Buffer(make([]byte, 64*1024), 1<<30)
+		for scanner.Scan() {
+			select {
+			case stream.lines <- scanner.Text():
+			case <-stream.done:
+				return
+			}
+		}
+	}()
+
+	binlogStreamsMutex.Lock()
+	binlogStreams[key] = stream
+	binlogStreamsMutex.Unlock()
+
+	return stream, nil
+}
+
+// closeBinlogStream terminates a running stream and removes it from the
+// list of active streams.
+func closeBinlogStream(key string) {
+	binlogStreamsMutex.Lock()
+	stream, ok := binlogStreams[key]
+	delete(binlogStreams, key)
+	binlogStreamsMutex.Unlock()
+	if !ok {
+		return
+	}
+	close(stream.done)
+	if stream.cmd.ProcessState == nil {
+		stream.cmd.Process.Kill()
+		stream.cmd.Wait()
+	}
+}
+
+// closeBinlogStreams terminates all running streams for a source server
+// and database.
+func closeBinlogStreams(addr, dbName string) {
+	prefix := addr + "/" + dbName + "."
+	keys := make([]string, 0)
+	binlogStreamsMutex.Lock()
+	for k := range binlogStreams {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	binlogStreamsMutex.Unlock()
+	for _, k := range keys {
+		closeBinlogStream(k)
+	}
+}
+
+// wait collects the exit status of the mysqlbinlog process once its output
+// has been exhausted.
+func (s *binlogStream) wait() error {
+	err := s.cmd.Wait()
+	msg := strings.TrimSpace(s.stderr.String())
+	if err == nil {
+		return fmt.Errorf("mysqlbinlog terminated unexpectedly: %s", msg)
+	}
+	return fmt.Errorf("%s: %s", err.Error(), msg)
+}
+
+// parse processes a single line of mysqlbinlog verbose output.
+func (s *binlogStream) parse(line string) error {
+	switch {
+	case strings.HasPrefix(line, "###"):
+		if m := binlogRowRegex.FindStringSubmatch(line); m != nil {
+			s.finishRow()
+			s.row = &SQLRow{Data: SQLUntypedRow{}}
+			s.rowMatches = m[2] == s.dbName && m[3] == s.tableName
+			s.rowSection = ""
+			switch m[1] {
+			case "INSERT INTO":
+				s.row.Method = "INSERT"
+				if s.replace {
+					s.row.Method = "REPLACE"
+				}
+				s.wantSection = "SET"
+			case "UPDATE":
+				s.row.Method = "REPLACE"
+				s.wantSection = "SET"
+			case "DELETE FROM":
+				s.row.Method = "REMOVE"
+				s.wantSection = "WHERE"
+			}
+			return nil
+		}
+		if s.row == nil || !s.rowMatches {
+			return nil
+		}
+		switch strings.TrimSpace(strings.TrimPrefix(line, "###")) {
+		case "SET":
+			s.rowSection = "SET"
+			return nil
+		case "WHERE":
+			s.rowSection = "WHERE"
+			return nil
+		}
+		if s.rowSection != s.wantSection {
+			return nil
+		}
+		m := binlogColumnRegex.FindStringSubmatch(line)
+		if m == nil {
+			return nil
+		}
+		idx, err := strconv.Atoi(m[1])
+		if err != nil {
+			return err
+		}
+		if idx < 1 || idx > len(s.columns) {
+			return fmt.Errorf("column @%d is not present in %s.%s, the table definition may have changed", idx, s.dbName, s.tableName)
+		}
+		col := s.columns[idx-1]
+		v, err := binlogValue(m[2], col)
+		if err != nil {
+			return fmt.Errorf("column %s: %s", col.Name, err.Error())
+		}
+		s.row.Data[col.Name] = v
+
+	case strings.HasPrefix(line, "# at "):
+		s.finishRow()
+
+	case strings.HasPrefix(line, "#"):
+		if m := binlogRotateRegex.FindStringSubmatch(line); m != nil {
+			s.currentFile = m[1]
+			return nil
+		}
+		if m := binlogEndLogPosRegex.FindStringSubmatch(line); m != nil {
+			pos, err := strconv.ParseInt(m[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			s.endLogPos = pos
+		}
+
+	case strings.HasPrefix(line, "SET @@SESSION.GTID_NEXT="):
+		if m := binlogGtidNextRegex.FindStringSubmatch(line); m != nil && m[1] != "AUTOMATIC" {
+			s.pendingGtid = m[1]
+		}
+
+	case line == "COMMIT/*!*/;" || line == "COMMIT":
+		s.finishRow()
+		s.committed = append(s.committed, s.pending...)
+		s.pending = s.pending[:0]
+		s.file = s.currentFile
+		s.pos = s.endLogPos
+		if s.useGtid && s.pendingGtid != "" {
+			err := s.gtid.Add(s.pendingGtid)
+			if err != nil {
+				return err
+			}
+		}
+		s.pendingGtid = ""
+	}
+	return nil
+}
+
+// finishRow moves the row currently being assembled to the list of rows
+// belonging to the open transaction.
+func (s *binlogStream) finishRow() {
+	if s.row == nil {
+		return
+	}
+	if s.rowMatches {
+		if s.row.Method == "REMOVE" && len(s.keys) > 0 {
+			// Only match on the key columns when removing
+			keyed := SQLUntypedRow{}
+			for _, k := range s.keys {
+				keyed[k] = s.row.Data[k]
+			}
+			s.row.Data = keyed
+		}
+		s.pending = append(s.pending, *s.row)
+	}
+	s.row = nil
+}
+
+// binlogValue converts a value printed by mysqlbinlog into a value which
+// can be bound to a query against the destination.
+func binlogValue(raw string, col binlogColumn) (any, error) {
+	if strings.HasPrefix(raw, "'") {
+		// Quotes and backslashes within strings are hex escaped, so the
+		// next quote terminates the value.
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string value")
+		}
+		b, err := binlogUnquote(raw[1 : end+1])
+		if err != nil {
+			return nil, err
+		}
+		switch col.DataType {
+		case "char", "varchar", "tinytext", "text", "mediumtext", "longtext", "enum", "set", "json",
+			"date", "datetime", "time":
+			return string(b), nil
+		default:
+			return b, nil
+		}
+	}
+
+	// Strip the type comment added by --verbose --verbose
+	if idx := strings.Index(raw, " /*"); idx >= 0 {
+		raw = raw[:idx]
+	}
+	raw = strings.TrimSpace(raw)
+
+	if raw == "NULL" {
+		return nil, nil
+	}
+
+	switch col.DataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "year", "enum", "set":
+		if m := binlogUnsignedRegex.FindStringSubmatch(raw); m != nil {
+			if col.Unsigned {
+				return strconv.ParseUint(m[1], 10, 64)
+			}
+			raw = strings.Fields(raw)[0]
+		}
+		return strconv.ParseInt(raw, 10, 64)
+	case "float", "double", "real":
+		return strconv.ParseFloat(raw, 64)
+	case "timestamp":
+		// Timestamps are printed as seconds since the epoch
+		sec, frac, _ := strings.Cut(raw, ".")
+		s, err := strconv.ParseInt(sec, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		var ns int64
+		if frac != "" {
+			ns, err = strconv.ParseInt((frac + "000000000")[:9], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return time.Unix(s, ns).UTC(), nil
+	case "bit":
+		return strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(raw, "b'"), "'"), 2, 64)
+	default:
+		return raw, nil
+	}
+}
+
+// binlogUnquote decodes the \xNN escapes used by mysqlbinlog for
+// non-printable characters, quotes and backslashes.
+func binlogUnquote(s string) ([]byte, error) {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			b, err := strconv.ParseUint(s[i+2:i+4], 16, 8)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, byte(b))
+			i += 3
+			continue
+		}
+		out = append(out, s[i])
+	}
+	return out, nil
+}
+
+// binlogTableColumns retrieves the ordered column definitions for a table
+// from the source database.
+func binlogTableColumns(ctx context.Context, db *sql.DB, dbName, tableName string) ([]binlogColumn, error) {
+	rows, err := db.QueryContext(ctx, "SELECT COLUMN_NAME, DATA_TYPE, COLUMN_TYPE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]binlogColumn, 0)
+	for rows.Next() {
+		var col binlogColumn
+		var columnType string
+		err = rows.Scan(&col.Name, &col.DataType, &columnType)
+		if err != nil {
+			return nil, err
+		}
+		col.DataType = strings.ToLower(col.DataType)
+		col.Unsigned = strings.Contains(strings.ToLower(columnType), "unsigned")
+		out = append(out, col)
+	}
+	return out, rows.Err()
+}
+
+// binlogMasterStatus retrieves the current binary log file, position and
+// executed GTID set of the source server.
+func binlogMasterStatus(ctx context.Context, db *sql.DB) (string, int64, string, error) {
+	rows, err := db.QueryContext(ctx, "SHOW MASTER STATUS")
+	if err != nil {
+		// MySQL 8.4 and later
+		rows, err = db.QueryContext(ctx, "SHOW BINARY LOG STATUS")
+		if err != nil {
+			return "", 0, "", err
+		}
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", 0, "", err
+	}
+	if !rows.Next() {
+		return "", 0, "", errors.New("binary logging is not enabled on the source")
+	}
+	values := make([]sql.NullString, len(cols))
+	scanArgs := make([]any, len(cols))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	err = rows.Scan(scanArgs...)
+	if err != nil {
+		return "", 0, "", err
+	}
+	var file, gtid string
+	var pos int64
+	for i, c := range cols {
+		switch c {
+		case "File":
+			file = values[i].String
+		case "Position":
+			pos, err = strconv.ParseInt(values[i].String, 10, 64)
+			if err != nil {
+				return "", 0, "", err
+			}
+		case "Executed_Gtid_Set":
+			gtid = values[i].String
+		}
+	}
+	return file, pos, gtid, nil
+}
+
+// binlogGtidSet is a minimal representation of a MySQL GTID set, mapping
+// source UUIDs to sorted, non-overlapping transaction intervals.
+type binlogGtidSet map[string][][2]int64
+
+// parseBinlogGtidSet parses a GTID set in the textual format used by MySQL,
+// for example "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5:7".
+func parseBinlogGtidSet(s string) (binlogGtidSet, error) {
+	out := binlogGtidSet{}
+	s = strings.ReplaceAll(strings.ReplaceAll(s, "\n", ""), " ", "")
+	if s == "" {
+		return out, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Split(part, ":")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid GTID set '%s'", part)
+		}
+		for _, r := range fields[1:] {
+			start, end, found := strings.Cut(r, "-")
+			a, err := strconv.ParseInt(start, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			b := a
+			if found {
+				b, err = strconv.ParseInt(end, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+			}
+			out.addInterval(strings.ToLower(fields[0]), a, b)
+		}
+	}
+	return out, nil
+}
+
+// Add adds a single GTID, in the form "uuid:number", to the set.
+func (g binlogGtidSet) Add(gtid string) error {
+	uuid, n, found := strings.Cut(gtid, ":")
+	if !found {
+		return fmt.Errorf("invalid GTID '%s'", gtid)
+	}
+	gno, err := strconv.ParseInt(n, 10, 64)
+	if err != nil {
+		return err
+	}
+	g.addInterval(strings.ToLower(uuid), gno, gno)
+	return nil
+}
+
+// addInterval merges an inclusive interval of transaction numbers into the
+// set for a given source UUID.
+func (g binlogGtidSet) addInterval(uuid string, start, end int64) {
+	intervals := append(g[uuid], [2]int64{start, end})
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i][0] < intervals[j][0] })
+	merged := make([][2]int64, 0, len(intervals))
+	for _, iv := range intervals {
+		if n := len(merged); n > 0 && iv[0] <= merged[n-1][1]+1 {
+			merged[n-1][1] = int64max(merged[n-1][1], iv[1])
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	g[uuid] = merged
+}
+
+// Empty reports whether the set contains no transactions.
+func (g binlogGtidSet) Empty() bool {
+	return len(g) == 0
+}
+
+// String produces the textual MySQL representation of the set.
+func (g binlogGtidSet) String() string {
+	uuids := make([]string, 0, len(g))
+	for k := range g {
+		uuids = append(uuids, k)
+	}
+	sort.Strings(uuids)
+	parts := make([]string, 0, len(uuids))
+	for _, uuid := range uuids {
+		part := uuid
+		for _, iv := range g[uuid] {
+			if iv[0] == iv[1] {
+				part += fmt.Sprintf(":%d", iv[0])
+			} else {
+				part += fmt.Sprintf(":%d-%d", iv[0], iv[1])
+			}
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,183 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func init() {
+	ExtractorMap["keyset"] = ExtractorKeyset
+}
+
+// ExtractorKeyset is an Extractor instance which pages through the source
+// database table in key order, using one or more ( comma separated ) key
+// columns of any type. The key tuple of the last row extracted is stored
+// as the KeyPosition of the TrackingStatus.
+var ExtractorKeyset = func(ctx context.Context, db *sql.DB, dbName, tableName string, ts TrackingStatus, params *Parameters) (bool, []SQLRow, TrackingStatus, error) {
+	batchSize := paramInt(*params, ParamBatchSize, DefaultBatchSize)
+	sequentialReplace := paramBool(*params, ParamSequentialReplace, false)
+	debug := paramBool(*params, ParamDebug, false)
+
+	tag := fmt.Sprintf("ExtractorKeyset[%s.%s]: ", dbName, tableName)
+
+	moreData := false
+
+	if debug {
+		logger.Debugf(tag+"Beginning run with params %#v", params)
+	}
+
+	data := make([]SQLRow, 0)
+
+	keyCols := keysetColumns(ts.ColumnName)
+
+	query := "SELECT * FROM `" + tableName + "`"
+	args := make([]any, 0)
+	if ts.KeyPosition != "" {
+		position, err := DecodeKeyTuple(ts.KeyPosition)
+		if err != nil {
+			logger.Errorf(tag+"ERR: Unable to decode key position %s: %s", ts.KeyPosition, err.Error())
+			return false, data, ts, err
+		}
+		if len(position) != len(keyCols) {
+			err = fmt.Errorf("key position %s does not match key columns %s", ts.KeyPosition, ts.ColumnName)
+			logger.Errorf(tag+"ERR: %s", err.Error())
+			return false, data, ts, err
+		}
+		var where string
+		where, args = keysetAfter(keyCols, position)
+		query += " WHERE " + where
+	}
+	query += " ORDER BY " + keysetOrderBy(keyCols) + " LIMIT ?"
+	args = append(args, batchSize)
+
+	tsStart := time.Now()
+
+	if debug {
+		logger.Debugf(tag+"Query: \"%s\" %#v", query, args)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Errorf(tag+"ERR: %s", err.Error())
+		return false, data, ts, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return false, data, ts, err
+	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return false, data, ts, err
+	}
+	if debug {
+		logger.Debugf(tag+"Columns %v", cols)
+	}
+
+	// Map key columns to their position in the result set
+	keyIdx := make([]int, len(keyCols))
+	for i, k := range keyCols {
+		keyIdx[i] = -1
+		for j, c := range cols {
+			if c == k {
+				keyIdx[i] = j
+			}
+		}
+		if keyIdx[i] < 0 {
+			err = fmt.Errorf("key column %s not present in %s.%s", k, dbName, tableName)
+			logger.Errorf(tag+"ERR: %s", err.Error())
+			return false, data, ts, err
+		}
+	}
+
+	var lastKey []any
+	dataCount := 0
+	for rows.Next() {
+		dataCount++
+		scanArgs := make([]any, len(cols))
+		values := make([]any, len(cols))
+		for i := range values {
+			scanArgs[i] = &values[i]
+		}
+
+		err = rows.Scan(scanArgs...)
+		if err != nil {
+			logger.Errorf(tag+"Scan: %s", err.Error())
+			return false, data, ts, err
+		}
+
+		// De-reference fields
+		rowData := SQLRow{}
+		if sequentialReplace {
+			rowData.Method = "REPLACE"
+		} else {
+			rowData.Method = "INSERT"
+		}
+		rowData.Data = make(SQLUntypedRow, len(cols))
+		for i := range cols {
+			rowData.Data[cols[i]] = values[i]
+		}
+		data = append(data, rowData)
+
+		// Rows are ordered by key, so the last row holds the highest key
+		lastKey = make([]any, len(keyIdx))
+		for i, idx := range keyIdx {
+			lastKey[i] = keysetValue(values[idx], colTypes[idx])
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		logger.Errorf(tag+"Rows: %s", err.Error())
+		return false, data, ts, err
+	}
+
+	logger.Infof(tag+"Duration to extract %d rows: %s", dataCount, time.Since(tsStart).String())
+
+	if dataCount == 0 {
+		if debug {
+			logger.Debugf(tag+"Batch size %d, row count %d; indicating no more data", batchSize, dataCount)
+		}
+		return false, data, ts, nil
+	}
+
+	if dataCount < batchSize {
+		if debug {
+			logger.Debugf(tag+"Batch size %d, row count %d; indicating no more data", batchSize, dataCount)
+		}
+		moreData = false
+	} else {
+		if debug {
+			logger.Debugf(tag+"Batch size %d == row count %d; indicating more data", batchSize, dataCount)
+		}
+		moreData = true
+	}
+
+	keyPosition, err := EncodeKeyTuple(lastKey)
+	if err != nil {
+		logger.Errorf(tag+"ERR: %s", err.Error())
+		return false, data, ts, err
+	}
+	logger.Infof(tag+"%s key position %s", ts.ColumnName, keyPosition)
+
+	// Copy old object ...
+	newTs := &TrackingStatus{
+		Db:               ts.Db,
+		SourceDatabase:   ts.SourceDatabase,
+		SourceTable:      ts.SourceTable,
+		DestinationTable: ts.DestinationTable,
+		IterationID:      ts.IterationID,
+		ColumnName:       ts.ColumnName,
+		// ... with updates
+		KeyPosition: keyPosition,
+		LastRun:     NullTimeNow(),
+	}
+
+	if sequentialReplace {
+		(*params)[ParamMethod] = "REPLACE"
+	} else {
+		(*params)[ParamMethod] = "INSERT"
+	}
+
+	return moreData, data, *newTs, nil
+}
@@ -1,6 +1,7 @@
 package migrator
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
@@ -14,7 +15,7 @@ func init() {
 // ExtractorQueue is an Extractor instance which uses a table which is
 // triggered by INSERT or UPDATE to notify the extractor that it needs
 // to replicate a row.
-var ExtractorQueue = func(db *sql.DB, dbName, tableName string, ts TrackingStatus, params *Parameters) (bool, []SQLRow, TrackingStatus, error) {
+var ExtractorQueue = func(ctx context.Context, db *sql.DB, dbName, tableName string, ts TrackingStatus, params *Parameters) (bool, []SQLRow, TrackingStatus, error) {
 	batchSize := paramInt(*params, "BatchSize", DefaultBatchSize)
 	debug := paramBool(*params, ParamDebug, false)
 
@@ -32,7 +33,7 @@ var ExtractorQueue = func(db *sql.DB, dbName, tableName string, ts TrackingStatu
 
 	tsStart := time.Now()
 
-	rowsToProcess, err := db.Query("SELECT * FROM `"+RecordQueueTable+"` WHERE sourceDatabase = ? AND sourceTable = ? ORDER BY timestampUpdated LIMIT ?",
+	rowsToProcess, err := db.QueryContext(ctx, "SELECT * FROM `"+RecordQueueTable+"` WHERE sourceDatabase = ? AND sourceTable = ? ORDER BY timestampUpdated LIMIT ?",
 		dbName, tableName, DefaultBatchSize)
 	if err != nil {
 		logger.Errorf(tag+"Error extracting queue rows: %s", err.Error())
@@ -50,7 +51,7 @@ var ExtractorQueue = func(db *sql.DB, dbName, tableName string, ts TrackingStatu
 			&(rq.Method),
 		)
 		if err != nil {
-			logger.Errorf(tag + "Queue Scan: " + err.Error())
+			logger.Errorf(tag+"Queue Scan: %s", err.Error())
 			return false, data, ts, err
 		}
 
@@ -87,9 +88,9 @@ var ExtractorQueue = func(db *sql.DB, dbName, tableName string, ts TrackingStatu
 			for _, v := range qvRaw {
 				qv = append(qv, v)
 			}
-			rows, err = db.Query(qs, qv...)
+			rows, err = db.QueryContext(ctx, qs, qv...)
 		} else {
-			rows, err = db.Query("SELECT * FROM `"+tableName+"` WHERE `"+rq.PrimaryKeyColumnName+"` = ? LIMIT 1", rq.PrimaryKeyColumnValue)
+			rows, err = db.QueryContext(ctx, "SELECT * FROM `"+tableName+"` WHERE `"+rq.PrimaryKeyColumnName+"` = ? LIMIT 1", rq.PrimaryKeyColumnValue)
 		}
 		if err != nil {
 			return false, data, ts, err
@@ -112,7 +113,7 @@ var ExtractorQueue = func(db *sql.DB, dbName, tableName string, ts TrackingStatu
 
 			err = rows.Scan(scanArgs...)
 			if err != nil {
-				logger.Errorf(tag + "Scan: " + err.Error())
+				logger.Errorf(tag+"Scan: %s", err.Error())
 				return false, data, ts, err
 			}
 
@@ -158,10 +159,12 @@ var ExtractorQueue = func(db *sql.DB, dbName, tableName string, ts TrackingStatu
 
 	// Manually copy old tracking object ...
 	newTs := &TrackingStatus{
-		Db:             ts.Db,
-		SourceDatabase: ts.SourceDatabase,
-		SourceTable:    ts.SourceTable,
-		ColumnName:     ts.ColumnName,
+		Db:               ts.Db,
+		SourceDatabase:   ts.SourceDatabase,
+		SourceTable:      ts.SourceTable,
+		DestinationTable: ts.DestinationTable,
+		IterationID:      ts.IterationID,
+		ColumnName:       ts.ColumnName,
 		// ... with updates
 		SequentialPosition: ts.SequentialPosition,
 		LastRun:            NullTimeNow(),
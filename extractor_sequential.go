@@ -1,6 +1,7 @@
 package migrator
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"math"
@@ -14,7 +15,7 @@ func init() {
 // ExtractorSequential is an Extractor instance which uses the primary key
 // sequence to determine which rows should be extracted from the source
 // database table.
-var ExtractorSequential = func(db *sql.DB, dbName, tableName string, ts TrackingStatus, params *Parameters) (bool, []SQLRow, TrackingStatus, error) {
+var ExtractorSequential = func(ctx context.Context, db *sql.DB, dbName, tableName string, ts TrackingStatus, params *Parameters) (bool, []SQLRow, TrackingStatus, error) {
 	batchSize := paramInt(*params, ParamBatchSize, DefaultBatchSize)
 	sequentialReplace := paramBool(*params, ParamSequentialReplace, false)
 	debug := paramBool(*params, ParamDebug, false)
@@ -34,11 +35,11 @@ var ExtractorSequential = func(db *sql.DB, dbName, tableName string, ts Tracking
 	tsStart := time.Now()
 
 	if debug {
-		logger.Debugf(tag+"Query: \"SELECT * FROM `"+tableName+"` WHERE `"+ts.ColumnName+"` > %d LIMIT %d\"", ts.SequentialPosition, batchSize)
+		logger.Debugf(tag+"Query: \"SELECT * FROM `"+tableName+"` WHERE `"+ts.ColumnName+"` > %d ORDER BY `"+ts.ColumnName+"` LIMIT %d\"", ts.SequentialPosition, batchSize)
 	}
-	rows, err := db.Query("SELECT * FROM `"+tableName+"` WHERE `"+ts.ColumnName+"` > ? LIMIT ?", ts.SequentialPosition, batchSize)
+	rows, err := db.QueryContext(ctx, "SELECT * FROM `"+tableName+"` WHERE `"+ts.ColumnName+"` > ? ORDER BY `"+ts.ColumnName+"` LIMIT ?", ts.SequentialPosition, batchSize)
 	if err != nil {
-		logger.Errorf(tag + "ERR: " + err.Error())
+		logger.Errorf(tag+"ERR: %s", err.Error())
 		return false, data, ts, err
 	}
 	defer rows.Close()
@@ -60,7 +61,7 @@ var ExtractorSequential = func(db *sql.DB, dbName, tableName string, ts Tracking
 
 		err = rows.Scan(scanArgs...)
 		if err != nil {
-			logger.Errorf(tag + "Scan: " + err.Error())
+			logger.Errorf(tag+"Scan: %s", err.Error())
 			return false, data, ts, err
 		}
 
@@ -111,10 +112,12 @@ var ExtractorSequential = func(db *sql.DB, dbName, tableName string, ts Tracking
 	logger.Infof(tag+"%s seq value range %d - %d", ts.ColumnName, minSeq, maxSeq)
 	// Manually copy old tracking object ...
 	newTs := &TrackingStatus{
-		Db:             ts.Db,
-		SourceDatabase: ts.SourceDatabase,
-		SourceTable:    ts.SourceTable,
-		ColumnName:     ts.ColumnName,
+		Db:               ts.Db,
+		SourceDatabase:   ts.SourceDatabase,
+		SourceTable:      ts.SourceTable,
+		DestinationTable: ts.DestinationTable,
+		IterationID:      ts.IterationID,
+		ColumnName:       ts.ColumnName,
 		// ... with updates
 		SequentialPosition: maxSeq,
 		LastRun:            NullTimeNow(),
@@ -1,6 +1,7 @@
 package migrator
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"time"
@@ -12,7 +13,7 @@ func init() {
 
 // ExtractorTimestamp is an Extractor instance which uses a DATETIME/TIMESTAMP
 // field to determine which rows to pull from the source database table.
-var ExtractorTimestamp = func(db *sql.DB, dbName, tableName string, ts TrackingStatus, params *Parameters) (bool, []SQLRow, TrackingStatus, error) {
+var ExtractorTimestamp = func(ctx context.Context, db *sql.DB, dbName, tableName string, ts TrackingStatus, params *Parameters) (bool, []SQLRow, TrackingStatus, error) {
 	batchSize := paramInt(*params, ParamBatchSize, DefaultBatchSize)
 	debug := paramBool(*params, ParamDebug, false)
 	onlyPast := paramBool(*params, ParamOnlyPast, false)
@@ -40,12 +41,12 @@ var ExtractorTimestamp = func(db *sql.DB, dbName, tableName string, ts TrackingS
 	var rows *sql.Rows
 	var err error
 	if onlyPast {
-		rows, err = db.Query("SELECT * FROM `"+tableName+"` WHERE `"+ts.ColumnName+"` > ? AND `"+ts.ColumnName+"` <= NOW() LIMIT ?", ts.TimestampPosition, batchSize)
+		rows, err = db.QueryContext(ctx, "SELECT * FROM `"+tableName+"` WHERE `"+ts.ColumnName+"` > ? AND `"+ts.ColumnName+"` <= NOW() LIMIT ?", ts.TimestampPosition, batchSize)
 	} else {
-		rows, err = db.Query("SELECT * FROM `"+tableName+"` WHERE `"+ts.ColumnName+"` > ? LIMIT ?", ts.TimestampPosition, batchSize)
+		rows, err = db.QueryContext(ctx, "SELECT * FROM `"+tableName+"` WHERE `"+ts.ColumnName+"` > ? LIMIT ?", ts.TimestampPosition, batchSize)
 	}
 	if err != nil {
-		logger.Errorf(tag + "ERR: " + err.Error())
+		logger.Errorf(tag+"ERR: %s", err.Error())
 		return false, data, ts, err
 	}
 	defer rows.Close()
@@ -67,7 +68,7 @@ var ExtractorTimestamp = func(db *sql.DB, dbName, tableName string, ts TrackingS
 
 		err = rows.Scan(scanArgs...)
 		if err != nil {
-			logger.Errorf(tag + "Scan: " + err.Error())
+			logger.Errorf(tag+"Scan: %s", err.Error())
 			return false, data, ts, err
 		}
 
@@ -111,13 +112,14 @@ var ExtractorTimestamp = func(db *sql.DB, dbName, tableName string, ts TrackingS
 	if debug {
 		logger.Debugf(tag+"%s high timestamp value %#v", ts.ColumnName, maxStamp)
 	}
-	err = SetTrackingStatusTimestamp(ts.Db, dbName, tableName, maxStamp)
 	// Copy old object ...
 	newTs := &TrackingStatus{
-		Db:             ts.Db,
-		SourceDatabase: ts.SourceDatabase,
-		SourceTable:    ts.SourceTable,
-		ColumnName:     ts.ColumnName,
+		Db:               ts.Db,
+		SourceDatabase:   ts.SourceDatabase,
+		SourceTable:      ts.SourceTable,
+		DestinationTable: ts.DestinationTable,
+		IterationID:      ts.IterationID,
+		ColumnName:       ts.ColumnName,
 		// ... with updates
 		TimestampPosition: NullTimeFromTime(maxStamp),
 		LastRun:           NullTimeFromTime(tsStart),
@@ -125,5 +127,5 @@ var ExtractorTimestamp = func(db *sql.DB, dbName, tableName string, ts TrackingS
 
 	(*params)[ParamMethod] = "REPLACE"
 
-	return moreData, data, *newTs, err
+	return moreData, data, *newTs, nil
 }
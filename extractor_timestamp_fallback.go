@@ -1,6 +1,7 @@
 package migrator
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
@@ -13,7 +14,7 @@ func init() {
 
 // ExtractorTimestampFallback is an Extractor instance which uses a DATETIME/TIMESTAMP
 // field to determine which rows to pull from the source database table.
-var ExtractorTimestampFallback = func(db *sql.DB, dbName, tableName string, ts TrackingStatus, params *Parameters) (bool, []SQLRow, TrackingStatus, error) {
+var ExtractorTimestampFallback = func(ctx context.Context, db *sql.DB, dbName, tableName string, ts TrackingStatus, params *Parameters) (bool, []SQLRow, TrackingStatus, error) {
 	batchSize := paramInt(*params, ParamBatchSize, DefaultBatchSize)
 	debug := paramBool(*params, ParamDebug, false)
 
@@ -34,16 +35,16 @@ var ExtractorTimestampFallback = func(db *sql.DB, dbName, tableName string, ts T
 	colnames := strings.Split(ts.ColumnName, ",")
 	if len(colnames) < 2 {
 		err := fmt.Errorf("requires two columns separated by a comma")
-		logger.Errorf(tag + "ERR: " + err.Error())
+		logger.Errorf(tag+"ERR: %s", err.Error())
 		return false, data, ts, err
 	}
 
 	if debug {
 		logger.Debugf(tag+"Query: \"SELECT * FROM `"+tableName+"` WHERE IFNULL(`"+colnames[0]+"`,`"+colnames[1]+"`) > %v LIMIT %d\"", ts.TimestampPosition, batchSize)
 	}
-	rows, err := db.Query("SELECT * FROM `"+tableName+"` WHERE IFNULL(`"+colnames[0]+"`,`"+colnames[1]+"`) > ? LIMIT ?", ts.TimestampPosition, batchSize)
+	rows, err := db.QueryContext(ctx, "SELECT * FROM `"+tableName+"` WHERE IFNULL(`"+colnames[0]+"`,`"+colnames[1]+"`) > ? LIMIT ?", ts.TimestampPosition, batchSize)
 	if err != nil {
-		logger.Errorf(tag + "ERR: " + err.Error())
+		logger.Errorf(tag+"ERR: %s", err.Error())
 		return false, data, ts, err
 	}
 	defer rows.Close()
@@ -65,7 +66,7 @@ var ExtractorTimestampFallback = func(db *sql.DB, dbName, tableName string, ts T
 
 		err = rows.Scan(scanArgs...)
 		if err != nil {
-			logger.Errorf(tag + "Scan: " + err.Error())
+			logger.Errorf(tag+"Scan: %s", err.Error())
 			return false, data, ts, err
 		}
 
@@ -109,13 +110,14 @@ var ExtractorTimestampFallback = func(db *sql.DB, dbName, tableName string, ts T
 	if debug {
 		logger.Debugf(tag+"%s high timestamp value %#v", ts.ColumnName, maxStamp)
 	}
-	err = SetTrackingStatusTimestamp(ts.Db, dbName, tableName, maxStamp)
 	// Copy old object ...
 	newTs := &TrackingStatus{
-		Db:             ts.Db,
-		SourceDatabase: ts.SourceDatabase,
-		SourceTable:    ts.SourceTable,
-		ColumnName:     ts.ColumnName,
+		Db:               ts.Db,
+		SourceDatabase:   ts.SourceDatabase,
+		SourceTable:      ts.SourceTable,
+		DestinationTable: ts.DestinationTable,
+		IterationID:      ts.IterationID,
+		ColumnName:       ts.ColumnName,
 		// ... with updates
 		TimestampPosition: NullTimeFromTime(maxStamp),
 		LastRun:           NullTimeFromTime(tsStart),
@@ -123,5 +125,5 @@ var ExtractorTimestampFallback = func(db *sql.DB, dbName, tableName string, ts T
 
 	(*params)[ParamMethod] = "REPLACE"
 
-	return moreData, data, *newTs, err
+	return moreData, data, *newTs, nil
 }
@@ -0,0 +1,207 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func init() {
+	ExtractorMap["timestamp_keyset"] = ExtractorTimestampKeyset
+}
+
+// ExtractorTimestampKeyset is an Extractor instance which uses a
+// DATETIME/TIMESTAMP field, followed by one or more key fields ( for
+// example "updated,id" ), to determine which rows to pull from the source
+// database table. Rows are ordered by the timestamp and then the key, and
+// the compound ( timestamp, key ) watermark of the last row is tracked in
+// the TimestampPosition and KeyPosition of the TrackingStatus, so that
+// rows sharing a timestamp are never skipped across batch boundaries.
+var ExtractorTimestampKeyset = func(ctx context.Context, db *sql.DB, dbName, tableName string, ts TrackingStatus, params *Parameters) (bool, []SQLRow, TrackingStatus, error) {
+	batchSize := paramInt(*params, ParamBatchSize, DefaultBatchSize)
+	debug := paramBool(*params, ParamDebug, false)
+	onlyPast := paramBool(*params, ParamOnlyPast, false)
+	lookback := paramInt(*params, ParamLookback, 0)
+
+	tag := fmt.Sprintf("ExtractorTimestampKeyset[%s.%s]: ", dbName, tableName)
+
+	moreData := false
+
+	if debug {
+		logger.Debugf(tag+"Beginning run with params %#v", params)
+	}
+
+	data := make([]SQLRow, 0)
+
+	cols := keysetColumns(ts.ColumnName)
+	if len(cols) < 2 {
+		err := fmt.Errorf("requires a timestamp column followed by key columns, separated by commas")
+		logger.Errorf(tag+"ERR: %s", err.Error())
+		return false, data, ts, err
+	}
+	stampCol := cols[0]
+
+	query := "SELECT * FROM `" + tableName + "` WHERE `" + stampCol + "` IS NOT NULL"
+	args := make([]any, 0)
+	if ts.TimestampPosition.Valid {
+		position := []any{ts.TimestampPosition.Time}
+		if ts.KeyPosition != "" {
+			key, err := DecodeKeyTuple(ts.KeyPosition)
+			if err != nil {
+				logger.Errorf(tag+"ERR: Unable to decode key position %s: %s", ts.KeyPosition, err.Error())
+				return false, data, ts, err
+			}
+			if len(key) != len(cols)-1 {
+				err = fmt.Errorf("key position %s does not match key columns %s", ts.KeyPosition, ts.ColumnName)
+				logger.Errorf(tag+"ERR: %s", err.Error())
+				return false, data, ts, err
+			}
+			position = append(position, key...)
+		}
+		where, whereArgs := keysetAfter(cols[:len(position)], position)
+		query += " AND ( " + where + " )"
+		args = append(args, whereArgs...)
+	}
+	if lookback > 0 {
+		// Leave recent rows until transactions which may still be
+		// committing with earlier timestamps have had time to do so
+		query += " AND `" + stampCol + "` <= NOW() - INTERVAL ? SECOND"
+		args = append(args, lookback)
+	} else if onlyPast {
+		query += " AND `" + stampCol + "` <= NOW()"
+	}
+	query += " ORDER BY " + keysetOrderBy(cols) + " LIMIT ?"
+	args = append(args, batchSize)
+
+	tsStart := time.Now()
+
+	if debug {
+		logger.Debugf(tag+"Query: \"%s\" %#v", query, args)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Errorf(tag+"ERR: %s", err.Error())
+		return false, data, ts, err
+	}
+	defer rows.Close()
+	rowCols, err := rows.Columns()
+	if err != nil {
+		return false, data, ts, err
+	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return false, data, ts, err
+	}
+	if debug {
+		logger.Debugf(tag+"Columns %v", rowCols)
+	}
+
+	// Map watermark columns to their position in the result set
+	idx := make([]int, len(cols))
+	for i, k := range cols {
+		idx[i] = -1
+		for j, c := range rowCols {
+			if c == k {
+				idx[i] = j
+			}
+		}
+		if idx[i] < 0 {
+			err = fmt.Errorf("column %s not present in %s.%s", k, dbName, tableName)
+			logger.Errorf(tag+"ERR: %s", err.Error())
+			return false, data, ts, err
+		}
+	}
+
+	var lastStamp time.Time
+	var lastKey []any
+	dataCount := 0
+	for rows.Next() {
+		dataCount++
+		scanArgs := make([]any, len(rowCols))
+		values := make([]any, len(rowCols))
+		for i := range values {
+			scanArgs[i] = &values[i]
+		}
+
+		err = rows.Scan(scanArgs...)
+		if err != nil {
+			logger.Errorf(tag+"Scan: %s", err.Error())
+			return false, data, ts, err
+		}
+
+		// De-reference fields
+		rowData := SQLRow{Method: "REPLACE"}
+		rowData.Data = make(SQLUntypedRow, len(rowCols))
+		for i := range rowCols {
+			rowData.Data[rowCols[i]] = values[i]
+		}
+		data = append(data, rowData)
+
+		// Rows are ordered, so the last row holds the watermark
+		timestamp, ok := values[idx[0]].(time.Time)
+		if !ok {
+			err = fmt.Errorf("column %s is not a Time", stampCol)
+			logger.Errorf(tag+"ERROR: Unable to process table %s: %s", dbName+"."+tableName, err.Error())
+			return false, data, ts, err
+		}
+		lastStamp = timestamp
+		lastKey = make([]any, len(cols)-1)
+		for i := range lastKey {
+			lastKey[i] = keysetValue(values[idx[i+1]], colTypes[idx[i+1]])
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		logger.Errorf(tag+"Rows: %s", err.Error())
+		return false, data, ts, err
+	}
+
+	logger.Infof(tag+"Duration to extract %d rows: %s", dataCount, time.Since(tsStart).String())
+
+	if dataCount == 0 {
+		if debug {
+			logger.Debugf(tag+"Batch size %d, row count %d; indicating no more data", batchSize, dataCount)
+		}
+		return false, data, ts, nil
+	}
+
+	if dataCount < batchSize {
+		if debug {
+			logger.Debugf(tag+"Batch size %d, row count %d; indicating no more data", batchSize, dataCount)
+		}
+		moreData = false
+	} else {
+		if debug {
+			logger.Debugf(tag+"Batch size %d == row count %d; indicating more data", batchSize, dataCount)
+		}
+		moreData = true
+	}
+
+	keyPosition, err := EncodeKeyTuple(lastKey)
+	if err != nil {
+		logger.Errorf(tag+"ERR: %s", err.Error())
+		return false, data, ts, err
+	}
+	if debug {
+		logger.Debugf(tag+"%s watermark ( %s, %s )", ts.ColumnName, lastStamp.String(), keyPosition)
+	}
+
+	// Copy old object ...
+	newTs := &TrackingStatus{
+		Db:               ts.Db,
+		SourceDatabase:   ts.SourceDatabase,
+		SourceTable:      ts.SourceTable,
+		DestinationTable: ts.DestinationTable,
+		IterationID:      ts.IterationID,
+		ColumnName:       ts.ColumnName,
+		// ... with updates
+		TimestampPosition: NullTimeFromTime(lastStamp),
+		KeyPosition:       keyPosition,
+		LastRun:           NullTimeFromTime(tsStart),
+	}
+
+	(*params)[ParamMethod] = "REPLACE"
+
+	return moreData, data, *newTs, nil
+}
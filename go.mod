@@ -9,7 +9,9 @@ require (
 	github.com/go-sql-driver/mysql v1.9.3
 	github.com/robertkrimen/otto v0.5.1
 	github.com/sirupsen/logrus v1.9.4
+	github.com/syndtr/goleveldb v1.0.0
 	go.elastic.co/apm/module/apmsql v1.15.0
+	golang.org/x/time v0.14.0
 )
 
 require (
@@ -23,7 +25,6 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/prometheus/procfs v0.19.2 // indirect
 	github.com/santhosh-tekuri/jsonschema v1.2.4 // indirect
-	github.com/syndtr/goleveldb v1.0.0 // indirect
 	go.elastic.co/apm v1.15.0 // indirect
 	go.elastic.co/fastjson v1.5.1 // indirect
 	golang.org/x/lint v0.0.0-20241112194109-818c5a804067 // indirect
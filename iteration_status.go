@@ -0,0 +1,376 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// IterationStatus is a point in time snapshot of the status of a single
+// Iteration within a Migrator.
+type IterationStatus struct {
+	// ID is the optional ID of the Iteration
+	ID string
+	// SourceTable is the table from which the Iteration extracts data
+	SourceTable string
+	// DestinationTable is the table into which the Iteration loads data
+	DestinationTable string
+	// State is the current state of the Iteration
+	State IterationState
+	// Paused indicates that the Iteration itself has been paused, as
+	// opposed to the whole Migrator
+	Paused bool
+	// LastError is the most recent error encountered by the Iteration
+	LastError error
+	// LastErrorTime is the time at which LastError was encountered
+	LastErrorTime time.Time
+	// LastBatchSize is the number of rows extracted in the most recent
+	// batch
+	LastBatchSize int
+	// LastRun is the time at which the most recent batch was completed
+	LastRun time.Time
+	// BatchSize is the number of rows the Extractor is currently asked to
+	// extract per batch, which changes if adaptive batch sizing is enabled
+	BatchSize int
+	// InsertBatchSize is the number of rows the Loader is currently asked
+	// to load per statement, which changes if adaptive batch sizing is
+	// enabled
+	InsertBatchSize int
+}
+
+// iterationStatus holds the live status of an Iteration, which is shared
+// between the goroutine running the Iteration and its controllers.
+type iterationStatus struct {
+	mutex         sync.Mutex
+	state         IterationState
+	paused        bool
+	changed       chan struct{}
+	lastError     error
+	lastErrorTime time.Time
+	lastBatchSize int
+	lastRun       time.Time
+	trigger       chan struct{}
+	running       bool
+	rewind        *TrackingStatus
+	batchSize     int
+	insertSize    int
+}
+
+func newIterationStatus() *iterationStatus {
+	return &iterationStatus{
+		changed: make(chan struct{}),
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// notify wakes anything waiting for a change in status. The mutex must be
+// held by the caller.
+func (s *iterationStatus) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *iterationStatus) setState(state IterationState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.state = state
+	s.notify()
+}
+
+func (s *iterationStatus) setPaused(paused bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.paused = paused
+	s.notify()
+}
+
+func (s *iterationStatus) setError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastError = err
+	s.lastErrorTime = time.Now()
+}
+
+func (s *iterationStatus) setBatch(size int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastBatchSize = size
+	s.lastRun = time.Now()
+}
+
+func (s *iterationStatus) setBatchSizes(batchSize, insertSize int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.batchSize = batchSize
+	s.insertSize = insertSize
+}
+
+// start marks the goroutine running the Iteration as started, so that
+// rewinds are left for it to apply.
+func (s *iterationStatus) start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.running = true
+}
+
+// stop marks the goroutine running the Iteration as stopped, returning and
+// clearing any rewind of the tracking position which it did not apply.
+func (s *iterationStatus) stop() *TrackingStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.running = false
+	position := s.rewind
+	s.rewind = nil
+	return position
+}
+
+// takeRewind returns and clears any pending rewind of the tracking
+// position.
+func (s *iterationStatus) takeRewind() *TrackingStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	position := s.rewind
+	s.rewind = nil
+	return position
+}
+
+// rewindPending reports whether a rewind of the tracking position has been
+// requested.
+func (s *iterationStatus) rewindPending() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.rewind != nil
+}
+
+// sleep waits for the specified duration, returning early if the Iteration
+// is triggered. It returns false if the context is cancelled.
+func (s *iterationStatus) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-s.trigger:
+		return true
+	case <-timer.C:
+		return true
+	}
+}
+
+// iterationStatus returns the live status for an Iteration, creating it if
+// necessary.
+func (m *Migrator) iterationStatus(x int) *iterationStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.Iterations[x].status == nil {
+		m.Iterations[x].status = newIterationStatus()
+	}
+	return m.Iterations[x].status
+}
+
+// findIteration resolves an Iteration by name, which is matched against the
+// ID of each Iteration and then against its source table. A source table
+// name which is shared by several Iterations is ambiguous, and those
+// Iterations must be referred to by ID.
+func (m *Migrator) findIteration(name string) (int, error) {
+	for x := range m.Iterations {
+		if m.Iterations[x].ID != "" && m.Iterations[x].ID == name {
+			return x, nil
+		}
+	}
+	found := -1
+	for x := range m.Iterations {
+		if m.Iterations[x].SourceTable != name {
+			continue
+		}
+		if found >= 0 {
+			return -1, errors.New("iteration name '" + name + "' is ambiguous, use an iteration ID")
+		}
+		found = x
+	}
+	if found < 0 {
+		return -1, errors.New("no iteration named '" + name + "'")
+	}
+	return found, nil
+}
+
+// PauseIteration pauses a single Iteration, identified by ID or source
+// table name, while the others continue to run. A batch which is already
+// being processed is completed first.
+func (m *Migrator) PauseIteration(name string) error {
+	x, err := m.findIteration(name)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Migrator.PauseIteration(): [%s] Pausing", m.trackingKey(x).String())
+	m.iterationStatus(x).setPaused(true)
+	return nil
+}
+
+// UnpauseIteration resumes a single Iteration, identified by ID or source
+// table name, which was paused with PauseIteration. An Iteration does not
+// run while its Migrator is paused, regardless of its own state.
+func (m *Migrator) UnpauseIteration(name string) error {
+	x, err := m.findIteration(name)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Migrator.UnpauseIteration(): [%s] Unpausing", m.trackingKey(x).String())
+	m.iterationStatus(x).setPaused(false)
+	return nil
+}
+
+// TriggerIteration causes a single Iteration, identified by ID or source
+// table name, to run immediately rather than waiting for the remainder of
+// its sleep between runs or before retrying. It has no effect on a paused
+// Iteration until it is unpaused.
+func (m *Migrator) TriggerIteration(name string) error {
+	x, err := m.findIteration(name)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Migrator.TriggerIteration(): [%s] Triggering run", m.trackingKey(x).String())
+	select {
+	case m.iterationStatus(x).trigger <- struct{}{}:
+	default:
+		// A run has already been triggered
+	}
+	return nil
+}
+
+// RewindIteration moves the tracking position of a single Iteration,
+// identified by ID or source table name, to the position held in the
+// specified TrackingStatus ( SequentialPosition, TimestampPosition,
+// BinlogFile, BinlogPosition, GtidSet and KeyPosition ). Despite the name,
+// the position may be moved forwards as well as backwards. If the Iteration
+// is running, the position is applied by the Iteration before its next
+// batch, so that a batch being loaded cannot overwrite it, or once it
+// stops if it stops first. Otherwise, including when the Iteration has
+// been stopped by an error while the migrator is running, the position is
+// written to the TrackingStore immediately.
+func (m *Migrator) RewindIteration(name string, position TrackingStatus) error {
+	x, err := m.findIteration(name)
+	if err != nil {
+		return err
+	}
+	tag := "Migrator.RewindIteration(): [" + m.trackingKey(x).String() + "] "
+
+	s := m.iterationStatus(x)
+	s.mutex.Lock()
+	running := s.running
+	if running {
+		s.rewind = &position
+	}
+	s.mutex.Unlock()
+
+	if running {
+		logger.Infof(tag+"Rewinding to %s before next batch", position.String())
+		select {
+		case s.trigger <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+	return m.rewindStoredPosition(x, position)
+}
+
+// rewindStoredPosition moves the tracking position of an Iteration which is
+// not running directly in the TrackingStore.
+func (m *Migrator) rewindStoredPosition(x int, position TrackingStatus) error {
+	tag := "Migrator.rewindStoredPosition(): [" + m.trackingKey(x).String() + "] "
+	if m.trackingStore == nil {
+		return errors.New(tag + "Not initialized")
+	}
+	ts, err := m.trackingStore.Get(m.trackingKey(x))
+	if err != nil {
+		return err
+	}
+	ts = rewindTrackingStatus(ts, position)
+	logger.Infof(tag+"Rewinding to %s", ts.String())
+	return m.trackingStore.Update(ts)
+}
+
+// rewindTrackingStatus copies the position fields of one TrackingStatus
+// onto another.
+func rewindTrackingStatus(ts, position TrackingStatus) TrackingStatus {
+	ts.SequentialPosition = position.SequentialPosition
+	ts.TimestampPosition = position.TimestampPosition
+	ts.BinlogFile = position.BinlogFile
+	ts.BinlogPosition = position.BinlogPosition
+	ts.GtidSet = position.GtidSet
+	ts.KeyPosition = position.KeyPosition
+	ts.LastRun = NullTimeNow()
+	return ts
+}
+
+// IterationStatus retrieves the status of a single Iteration, identified
+// by ID or source table name.
+func (m *Migrator) IterationStatus(name string) (IterationStatus, error) {
+	x, err := m.findIteration(name)
+	if err != nil {
+		return IterationStatus{}, err
+	}
+	return m.iterationStatusSnapshot(x), nil
+}
+
+// IterationStatuses retrieves the status of all Iterations, in the order in
+// which they are defined.
+func (m *Migrator) IterationStatuses() []IterationStatus {
+	out := make([]IterationStatus, len(m.Iterations))
+	for x := range m.Iterations {
+		out[x] = m.iterationStatusSnapshot(x)
+	}
+	return out
+}
+
+func (m *Migrator) iterationStatusSnapshot(x int) IterationStatus {
+	migratorPaused := m.State() == S_PAUSED
+
+	s := m.iterationStatus(x)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	out := IterationStatus{
+		ID:               m.Iterations[x].ID,
+		SourceTable:      m.Iterations[x].SourceTable,
+		DestinationTable: m.Iterations[x].DestinationTable,
+		State:            s.state,
+		Paused:           s.paused,
+		LastError:        s.lastError,
+		LastErrorTime:    s.lastErrorTime,
+		LastBatchSize:    s.lastBatchSize,
+		LastRun:          s.lastRun,
+		BatchSize:        s.batchSize,
+		InsertBatchSize:  s.insertSize,
+	}
+	if (s.paused || migratorPaused) && (s.state == I_RUNNING || s.state == I_BACKING_OFF) {
+		out.State = I_PAUSED
+	}
+	return out
+}
+
+// waitWhilePaused blocks while either the migrator or the Iteration is
+// paused, returning false if the context is cancelled.
+func (m *Migrator) waitWhilePaused(ctx context.Context, x int) bool {
+	s := m.iterationStatus(x)
+	for {
+		m.mutex.Lock()
+		state, migratorChanged := m.state, m.stateChanged
+		m.mutex.Unlock()
+
+		s.mutex.Lock()
+		paused, iterationChanged := s.paused, s.changed
+		s.mutex.Unlock()
+
+		if state != S_PAUSED && !paused {
+			return ctx.Err() == nil
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-migratorChanged:
+		case <-iterationChanged:
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EncodeKeyTuple serializes a tuple of key values into a string which can
+// be stored as the KeyPosition of a TrackingStatus. Each value is prefixed
+// with its type so that it can be restored without loss by DecodeKeyTuple.
+func EncodeKeyTuple(values []any) (string, error) {
+	encoded := make([]string, len(values))
+	for i, v := range values {
+		switch val := v.(type) {
+		case nil:
+			encoded[i] = "n:"
+		case int64:
+			encoded[i] = "i:" + strconv.FormatInt(val, 10)
+		case int:
+			encoded[i] = "i:" + strconv.Itoa(val)
+		case uint64:
+			encoded[i] = "u:" + strconv.FormatUint(val, 10)
+		case float64:
+			encoded[i] = "f:" + strconv.FormatFloat(val, 'g', -1, 64)
+		case string:
+			encoded[i] = "s:" + val
+		case []byte:
+			encoded[i] = "b:" + base64.StdEncoding.EncodeToString(val)
+		case time.Time:
+			encoded[i] = "t:" + val.Format(time.RFC3339Nano)
+		default:
+			return "", fmt.Errorf("EncodeKeyTuple(): unsupported key type %T", v)
+		}
+	}
+	out, err := json.Marshal(encoded)
+	return string(out), err
+}
+
+// DecodeKeyTuple restores a tuple of key values serialized by
+// EncodeKeyTuple.
+func DecodeKeyTuple(s string) ([]any, error) {
+	encoded := make([]string, 0)
+	err := json.Unmarshal([]byte(s), &encoded)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]any, len(encoded))
+	for i, e := range encoded {
+		t, v, found := strings.Cut(e, ":")
+		if !found {
+			return nil, fmt.Errorf("DecodeKeyTuple(): invalid key value '%s'", e)
+		}
+		switch t {
+		case "n":
+			out[i] = nil
+		case "i":
+			out[i], err = strconv.ParseInt(v, 10, 64)
+		case "u":
+			out[i], err = strconv.ParseUint(v, 10, 64)
+		case "f":
+			out[i], err = strconv.ParseFloat(v, 64)
+		case "s":
+			out[i] = v
+		case "b":
+			out[i], err = base64.StdEncoding.DecodeString(v)
+		case "t":
+			out[i], err = time.Parse(time.RFC3339Nano, v)
+		default:
+			return nil, fmt.Errorf("DecodeKeyTuple(): unknown key type '%s'", t)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// keysetColumns splits a comma separated list of key columns.
+func keysetColumns(columnName string) []string {
+	cols := strings.Split(columnName, ",")
+	for i := range cols {
+		cols[i] = strings.TrimSpace(cols[i])
+	}
+	return cols
+}
+
+// keysetOrderBy produces an ORDER BY clause ( without the keywords ) for
+// a list of key columns.
+func keysetOrderBy(cols []string) string {
+	return "`" + strings.Join(cols, "`, `") + "`"
+}
+
+// keysetAfter produces a WHERE clause condition ( without the keyword )
+// which selects rows whose key tuple sorts after the specified position,
+// along with the arguments to bind. The comparison is expanded, rather
+// than using a row constructor, so that MySQL can use a range scan on
+// the key index:
+//
+//	(a > ?) OR (a = ? AND b > ?) OR ...
+func keysetAfter(cols []string, position []any) (string, []any) {
+	clauses := make([]string, 0, len(cols))
+	args := make([]any, 0)
+	for i := range cols {
+		parts := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			parts = append(parts, "`"+cols[j]+"` = ?")
+			args = append(args, position[j])
+		}
+		parts = append(parts, "`"+cols[i]+"` > ?")
+		args = append(args, position[i])
+		clauses = append(clauses, "("+strings.Join(parts, " AND ")+")")
+	}
+	return strings.Join(clauses, " OR "), args
+}
+
+// keysetBefore produces a WHERE clause condition ( without the keyword )
+// which selects rows whose key tuple sorts before, or if inclusive is set,
+// at the specified position, along with the arguments to bind. It is the
+// counterpart of keysetAfter.
+func keysetBefore(cols []string, position []any, inclusive bool) (string, []any) {
+	clauses := make([]string, 0, len(cols)+1)
+	args := make([]any, 0)
+	for i := range cols {
+		parts := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			parts = append(parts, "`"+cols[j]+"` = ?")
+			args = append(args, position[j])
+		}
+		parts = append(parts, "`"+cols[i]+"` < ?")
+		args = append(args, position[i])
+		clauses = append(clauses, "("+strings.Join(parts, " AND ")+")")
+	}
+	if inclusive {
+		parts := make([]string, 0, len(cols))
+		for j := range cols {
+			parts = append(parts, "`"+cols[j]+"` = ?")
+			args = append(args, position[j])
+		}
+		clauses = append(clauses, "("+strings.Join(parts, " AND ")+")")
+	}
+	return strings.Join(clauses, " OR "), args
+}
+
+// keysetValue normalizes a scanned key value so that it compares against
+// the source column using the column's collation rather than as a binary
+// string.
+func keysetValue(v any, ct *sql.ColumnType) any {
+	b, ok := v.([]byte)
+	if !ok {
+		return v
+	}
+	switch strings.ToUpper(ct.DatabaseTypeName()) {
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "BIT", "GEOMETRY":
+		return b
+	default:
+		return string(b)
+	}
+}
+
+// queryer is implemented by *sql.DB, *sql.Conn and *sql.Tx, so that key
+// ranges can be read within a transaction or from a pool.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// keyCondition is a WHERE clause condition ( without the keyword ) along
+// with the arguments to bind.
+type keyCondition struct {
+	where string
+	args  []any
+}
+
+func (c keyCondition) and(where string, args []any) keyCondition {
+	if c.where == "" {
+		return keyCondition{where: where, args: args}
+	}
+	return keyCondition{where: "(" + c.where + ") AND (" + where + ")", args: append(append([]any{}, c.args...), args...)}
+}
+
+func (c keyCondition) clause() string {
+	if c.where == "" {
+		return ""
+	}
+	return " WHERE " + c.where
+}
+
+// keyRange restricts a condition to the range of keys ( lower, upper ],
+// either of which may be nil if the range is unbounded.
+func (c keyCondition) keyRange(cols []string, lower, upper []any) keyCondition {
+	if lower != nil {
+		c = c.and(keysetAfter(cols, lower))
+	}
+	if upper != nil {
+		c = c.and(keysetBefore(cols, upper, true))
+	}
+	return c
+}
+
+// chunkBoundary finds the key of the last row of the next chunk in the
+// source table, or nil if the remaining rows fit within a single chunk.
+func chunkBoundary(ctx context.Context, db queryer, table string, keyCols []string, c keyCondition, chunkSize int) ([]any, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+keysetOrderBy(keyCols)+" FROM `"+table+"`"+c.clause()+" ORDER BY "+keysetOrderBy(keyCols)+" LIMIT 1 OFFSET ?", append(append([]any{}, c.args...), chunkSize-1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]any, len(keyCols))
+	scanArgs := make([]any, len(keyCols))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	err = rows.Scan(scanArgs...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range values {
+		values[i] = keysetValue(values[i], colTypes[i])
+	}
+	return values, nil
+}
+
+// selectRows extracts the rows of a table matching a condition, in key
+// order, to be loaded with the specified method. If limit is positive, at
+// most that many rows are extracted. The key of the last row is returned
+// so that the next rows can be selected after it.
+func selectRows(ctx context.Context, db queryer, table string, keyCols []string, c keyCondition, limit int, method string) ([]SQLRow, []any, error) {
+	query := "SELECT * FROM `" + table + "`" + c.clause() + " ORDER BY " + keysetOrderBy(keyCols)
+	args := c.args
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(append([]any{}, c.args...), limit)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, err
+	}
+	keyIdx := make([]int, len(keyCols))
+	for i, k := range keyCols {
+		keyIdx[i] = -1
+		for j, c := range cols {
+			if c == k {
+				keyIdx[i] = j
+			}
+		}
+		if keyIdx[i] < 0 {
+			return nil, nil, fmt.Errorf("key column %s not present in %s", k, table)
+		}
+	}
+
+	data := make([]SQLRow, 0)
+	var lastKey []any
+	for rows.Next() {
+		scanArgs := make([]any, len(cols))
+		values := make([]any, len(cols))
+		for i := range values {
+			scanArgs[i] = &values[i]
+		}
+		err = rows.Scan(scanArgs...)
+		if err != nil {
+			return nil, nil, err
+		}
+		rowData := SQLRow{Method: method, Data: make(SQLUntypedRow, len(cols))}
+		for i := range cols {
+			rowData.Data[cols[i]] = values[i]
+		}
+		data = append(data, rowData)
+
+		lastKey = make([]any, len(keyIdx))
+		for i, idx := range keyIdx {
+			lastKey[i] = keysetValue(values[idx], colTypes[idx])
+		}
+	}
+	return data, lastKey, rows.Err()
+}
@@ -0,0 +1,199 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeyTupleRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 2, 29, 23, 59, 59, 123456000, time.UTC)
+	tests := []struct {
+		name   string
+		values []any
+		want   []any
+	}{
+		{"empty", []any{}, []any{}},
+		{"integer", []any{int64(-42)}, []any{int64(-42)}},
+		{"int is widened", []any{7}, []any{int64(7)}},
+		{"unsigned", []any{uint64(18446744073709551615)}, []any{uint64(18446744073709551615)}},
+		{"float", []any{1.5e-7}, []any{1.5e-7}},
+		{"string with separators", []any{`a:b,"c"]`}, []any{`a:b,"c"]`}},
+		{"empty string", []any{""}, []any{""}},
+		{"null", []any{nil}, []any{nil}},
+		{"binary", []any{[]byte{0, 0xff, ':', '"'}}, []any{[]byte{0, 0xff, ':', '"'}}},
+		{"time", []any{ts}, []any{ts}},
+		{"composite", []any{int64(1), "x", nil, []byte("y"), ts}, []any{int64(1), "x", nil, []byte("y"), ts}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := EncodeKeyTuple(tt.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := DecodeKeyTuple(s)
+			if err != nil {
+				t.Fatalf("DecodeKeyTuple(%s): %s", s, err.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeKeyTuple(%s): got %#v, expected %#v", s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyTupleDistinguishesTypes(t *testing.T) {
+	// Values with the same text must not decode to the same tuple
+	tuples := [][]any{{nil}, {""}, {[]byte{}}, {"1"}, {int64(1)}, {uint64(1)}, {float64(1)}}
+	seen := map[string]bool{}
+	for _, values := range tuples {
+		s, err := EncodeKeyTuple(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[s] {
+			t.Errorf("EncodeKeyTuple(%#v): %s is not unique", values, s)
+		}
+		seen[s] = true
+	}
+}
+
+func TestEncodeKeyTupleUnsupported(t *testing.T) {
+	if _, err := EncodeKeyTuple([]any{struct{}{}}); err == nil {
+		t.Error("expected an error for an unsupported type")
+	}
+}
+
+func TestDecodeKeyTupleInvalid(t *testing.T) {
+	for _, s := range []string{
+		``,
+		`not json`,
+		`["1"]`,
+		`["x:1"]`,
+		`["i:abc"]`,
+		`["u:-1"]`,
+		`["b:!!"]`,
+		`["t:yesterday"]`,
+	} {
+		if _, err := DecodeKeyTuple(s); err == nil {
+			t.Errorf("DecodeKeyTuple(%s): expected an error", s)
+		}
+	}
+}
+
+func TestKeysetAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		cols     []string
+		position []any
+		want     string
+		wantArgs []any
+	}{
+		{
+			name:     "single column",
+			cols:     []string{"id"},
+			position: []any{int64(5)},
+			want:     "(`id` > ?)",
+			wantArgs: []any{int64(5)},
+		},
+		{
+			name:     "two columns",
+			cols:     []string{"a", "b"},
+			position: []any{int64(1), "x"},
+			want:     "(`a` > ?) OR (`a` = ? AND `b` > ?)",
+			wantArgs: []any{int64(1), int64(1), "x"},
+		},
+		{
+			name:     "three columns",
+			cols:     []string{"a", "b", "c"},
+			position: []any{1, 2, 3},
+			want:     "(`a` > ?) OR (`a` = ? AND `b` > ?) OR (`a` = ? AND `b` = ? AND `c` > ?)",
+			wantArgs: []any{1, 1, 2, 1, 2, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args := keysetAfter(tt.cols, tt.position)
+			if got != tt.want {
+				t.Errorf("got %s, expected %s", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("arguments: got %v, expected %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestKeysetBefore(t *testing.T) {
+	tests := []struct {
+		name      string
+		cols      []string
+		position  []any
+		inclusive bool
+		want      string
+		wantArgs  []any
+	}{
+		{
+			name:     "single column",
+			cols:     []string{"id"},
+			position: []any{int64(5)},
+			want:     "(`id` < ?)",
+			wantArgs: []any{int64(5)},
+		},
+		{
+			name:      "single column inclusive",
+			cols:      []string{"id"},
+			position:  []any{int64(5)},
+			inclusive: true,
+			want:      "(`id` < ?) OR (`id` = ?)",
+			wantArgs:  []any{int64(5), int64(5)},
+		},
+		{
+			name:     "two columns",
+			cols:     []string{"a", "b"},
+			position: []any{1, "x"},
+			want:     "(`a` < ?) OR (`a` = ? AND `b` < ?)",
+			wantArgs: []any{1, 1, "x"},
+		},
+		{
+			name:      "two columns inclusive",
+			cols:      []string{"a", "b"},
+			position:  []any{1, "x"},
+			inclusive: true,
+			want:      "(`a` < ?) OR (`a` = ? AND `b` < ?) OR (`a` = ? AND `b` = ?)",
+			wantArgs:  []any{1, 1, "x", 1, "x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args := keysetBefore(tt.cols, tt.position, tt.inclusive)
+			if got != tt.want {
+				t.Errorf("got %s, expected %s", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("arguments: got %v, expected %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestKeysetColumns(t *testing.T) {
+	tests := []struct {
+		columnName string
+		want       []string
+		orderBy    string
+	}{
+		{"id", []string{"id"}, "`id`"},
+		{"a,b", []string{"a", "b"}, "`a`, `b`"},
+		{" a , b ,c", []string{"a", "b", "c"}, "`a`, `b`, `c`"},
+	}
+	for _, tt := range tests {
+		got := keysetColumns(tt.columnName)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("keysetColumns(%q): got %v, expected %v", tt.columnName, got, tt.want)
+		}
+		if orderBy := keysetOrderBy(got); orderBy != tt.orderBy {
+			t.Errorf("keysetOrderBy(%v): got %s, expected %s", got, orderBy, tt.orderBy)
+		}
+	}
+}
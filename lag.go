@@ -0,0 +1,213 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrLagExceeded is passed to the ErrorCallback, wrapped with a
+// description of the lag, when an Iteration falls further behind its
+// source than its configured thresholds and no AlertCallback is set.
+var ErrLagExceeded = errors.New("lag threshold exceeded")
+
+// IterationLag describes how far an Iteration is behind its source table.
+// Only the fields which apply to the Extractor being used are populated.
+type IterationLag struct {
+	// Extractor is the name of the Extractor used to calculate the lag
+	Extractor string
+	// Keys is the difference between the maximum key in the source table
+	// and the tracked SequentialPosition ( sequential )
+	Keys int64
+	// Rows is the number of source rows after the tracked KeyPosition
+	// ( keyset )
+	Rows int64
+	// QueueDepth is the number of entries waiting in the RecordQueueTable
+	// for the source table ( queue )
+	QueueDepth int64
+	// BinlogBytes is the number of bytes between the tracked binlog
+	// position and the current position of the source, if both are in the
+	// same binlog file ( binlog )
+	BinlogBytes int64
+	// Behind is the difference between the latest timestamp in the source
+	// table and the tracked TimestampPosition ( timestamp,
+	// timestamp_fallback, timestamp_keyset ), or the age of the oldest
+	// entry in the RecordQueueTable ( queue )
+	Behind time.Duration
+	// CheckedAt is the time at which the lag was calculated
+	CheckedAt time.Time
+}
+
+// String returns a human readable description of the lag.
+func (l IterationLag) String() string {
+	return fmt.Sprintf("%s: keys=%d rows=%d queue=%d binlog-bytes=%d behind=%s", l.Extractor, l.Keys, l.Rows, l.QueueDepth, l.BinlogBytes, l.Behind.String())
+}
+
+// exceeds determines whether the lag is beyond the specified thresholds. A
+// threshold of zero is not checked.
+func (l IterationLag) exceeds(seconds, rows int) bool {
+	if seconds > 0 && l.Behind > time.Duration(seconds)*time.Second {
+		return true
+	}
+	if rows > 0 && max(l.Keys, l.Rows, l.QueueDepth) > int64(rows) {
+		return true
+	}
+	return false
+}
+
+// Lag calculates how far a single Iteration, identified by ID or source
+// table name, is behind its source table, by comparing its tracked
+// position with the current contents of the source. The Iteration must
+// use one of the built in extractors, as named by its ExtractorName.
+func (m *Migrator) Lag(name string) (IterationLag, error) {
+	x, err := m.findIteration(name)
+	if err != nil {
+		return IterationLag{}, err
+	}
+	return m.lag(context.Background(), x)
+}
+
+func (m *Migrator) lag(ctx context.Context, x int) (IterationLag, error) {
+	m.mutex.Lock()
+	db := m.sourceDb
+	m.mutex.Unlock()
+	if db == nil || m.trackingStore == nil {
+		return IterationLag{}, errors.New("Migrator.Lag(): Not initialized")
+	}
+
+	ts, err := m.trackingStore.Get(m.trackingKey(x))
+	if err != nil {
+		return IterationLag{}, err
+	}
+
+	table := m.Iterations[x].SourceTable
+	out := IterationLag{Extractor: m.Iterations[x].ExtractorName, CheckedAt: time.Now()}
+
+	switch out.Extractor {
+	case "sequential":
+		var maxKey sql.NullInt64
+		err = db.QueryRowContext(ctx, "SELECT MAX(`"+ts.ColumnName+"`) FROM `"+table+"`").Scan(&maxKey)
+		if maxKey.Valid && maxKey.Int64 > ts.SequentialPosition {
+			out.Keys = maxKey.Int64 - ts.SequentialPosition
+		}
+
+	case "timestamp", "timestamp_fallback", "timestamp_keyset":
+		expr := "`" + ts.ColumnName + "`"
+		if out.Extractor == "timestamp_fallback" {
+			cols := keysetColumns(ts.ColumnName)
+			if len(cols) < 2 {
+				return out, errors.New("Migrator.Lag(): timestamp_fallback requires two columns separated by a comma")
+			}
+			expr = "IFNULL(`" + cols[0] + "`,`" + cols[1] + "`)"
+		} else if out.Extractor == "timestamp_keyset" {
+			expr = "`" + keysetColumns(ts.ColumnName)[0] + "`"
+		}
+		var maxStamp, minStamp NullTime
+		err = db.QueryRowContext(ctx, "SELECT MAX("+expr+"), MIN("+expr+") FROM `"+table+"`").Scan(&maxStamp, &minStamp)
+		if maxStamp.Valid {
+			// Nothing has been extracted yet, so the whole table is behind
+			position := minStamp.Time
+			if ts.TimestampPosition.Valid {
+				position = ts.TimestampPosition.Time
+			}
+			if maxStamp.Time.After(position) {
+				out.Behind = maxStamp.Time.Sub(position)
+			}
+		}
+
+	case "keyset":
+		cols := keysetColumns(ts.ColumnName)
+		query := "SELECT COUNT(*) FROM `" + table + "`"
+		args := make([]any, 0)
+		if ts.KeyPosition != "" {
+			position, err := DecodeKeyTuple(ts.KeyPosition)
+			if err != nil {
+				return out, err
+			}
+			if len(position) != len(cols) {
+				return out, fmt.Errorf("key position %s does not match key columns %s", ts.KeyPosition, ts.ColumnName)
+			}
+			var where string
+			where, args = keysetAfter(cols, position)
+			query += " WHERE " + where
+		}
+		err = db.QueryRowContext(ctx, query, args...).Scan(&out.Rows)
+
+	case "queue":
+		var oldest NullTime
+		err = db.QueryRowContext(ctx, "SELECT COUNT(*), MIN(timestampUpdated) FROM `"+RecordQueueTable+"` WHERE sourceDatabase = ? AND sourceTable = ?",
+			m.SourceDsn.DBName, table).Scan(&out.QueueDepth, &oldest)
+		if oldest.Valid {
+			out.Behind = out.CheckedAt.Sub(oldest.Time)
+		}
+
+	case "binlog":
+		var file string
+		var position int64
+		file, position, _, err = binlogMasterStatus(ctx, db)
+		if err == nil && file == ts.BinlogFile && position > ts.BinlogPosition {
+			out.BinlogBytes = position - ts.BinlogPosition
+		}
+
+	default:
+		return out, errors.New("Migrator.Lag(): Unable to calculate lag for extractor '" + out.Extractor + "'")
+	}
+
+	return out, err
+}
+
+// monitorLag periodically calculates the lag of an Iteration, alerting
+// when it first exceeds the ParamLagThreshold or ParamLagRowThreshold of
+// the Iteration, and again each time it exceeds them after recovering.
+func (m *Migrator) monitorLag(ctx context.Context, x int) {
+	tag := "Migrator.monitorLag(): [" + m.trackingKey(x).String() + "] "
+	params := *m.Iterations[x].Parameters
+	seconds := paramInt(params, ParamLagThreshold, 0)
+	rows := paramInt(params, ParamLagRowThreshold, 0)
+	interval := time.Duration(paramInt(params, ParamLagCheckInterval, 60)) * time.Second
+
+	exceeded := false
+	for {
+		if !sleepWithInterrupt(ctx, interval) {
+			return
+		}
+
+		lag, err := m.lag(ctx, x)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Warnf(tag+"Unable to calculate lag: %s", err.Error())
+			continue
+		}
+		logger.Debugf(tag+"Lag %s", lag.String())
+
+		if !lag.exceeds(seconds, rows) {
+			if exceeded {
+				logger.Infof(tag+"Lag recovered: %s", lag.String())
+			}
+			exceeded = false
+			continue
+		}
+		if exceeded {
+			continue
+		}
+		exceeded = true
+
+		logger.Warnf(tag+"Lag exceeded threshold: %s", lag.String())
+		details := map[string]string{
+			"Stage":            StageLag,
+			"SourceDb":         m.SourceDsn.DBName,
+			"SourceTable":      m.Iterations[x].SourceTable,
+			"DestinationDb":    m.DestinationDsn.DBName,
+			"DestinationTable": m.Iterations[x].DestinationTable,
+		}
+		if m.AlertCallback != nil {
+			m.AlertCallback(details, lag)
+		} else if m.ErrorCallback != nil {
+			m.ErrorCallback(details, fmt.Errorf("%w: %s", ErrLagExceeded, lag.String()))
+		}
+	}
+}
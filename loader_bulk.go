@@ -0,0 +1,214 @@
+package migrator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func init() {
+	LoaderMap["bulk"] = BulkLoader
+}
+
+// bulkReaders numbers the reader handlers registered by the bulk loader,
+// so that concurrent loads do not share a name.
+var bulkReaders atomic.Uint64
+
+// BulkLoader represents a Loader which streams INSERT and REPLACE rows
+// into each table with a single LOAD DATA LOCAL INFILE statement, rather
+// than batches of multi-row statements, which is considerably faster for
+// large batches and is not limited by max_allowed_packet. INSERT rows
+// which duplicate an existing key are skipped, as LOAD DATA LOCAL cannot
+// fail on them. UPSERT and REMOVE rows are loaded as they are by the
+// DefaultLoader. Like the DefaultLoader, all tables are loaded within a
+// single transaction which also updates the tracking table. The
+// destination server must have local_infile enabled.
+var BulkLoader = func(ctx context.Context, db *sql.DB, tables []TableData, ts TrackingStatus, params *Parameters) error {
+	return loadTransaction(ctx, db, "BulkLoader", tables, ts, params, loadBulk)
+}
+
+// loadBulk loads INSERT and REPLACE rows into a table with LOAD DATA LOCAL
+// INFILE, and any other rows with batched statements. As with batched
+// statements, consecutive rows with the same columns are loaded together.
+func loadBulk(ctx context.Context, tx *sql.Tx, table string, method string, rows []SQLUntypedRow, params *Parameters) error {
+	switch method {
+	case "UPSERT", "REMOVE":
+		return loadBatched(ctx, tx, table, method, rows, params)
+	default:
+	}
+
+	if len(rows) < 1 {
+		return fmt.Errorf("loadBulk(): [%s] no data presented", table)
+	}
+	for _, group := range columnGroups(rows) {
+		if len(group.columns) < 1 {
+			return fmt.Errorf("loadBulk(): [%s] no columns presented", table)
+		}
+		err := loadBulkGroup(ctx, tx, table, method, group, params)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadBulkGroup streams a group of rows which share the same columns into
+// a table with a single LOAD DATA LOCAL INFILE statement.
+func loadBulkGroup(ctx context.Context, tx *sql.Tx, table string, method string, group rowGroup, params *Parameters) error {
+	debug := paramBool(*params, ParamDebug, false)
+	tag := "loadBulk(" + table + "): "
+	columns, rows := group.columns, group.rows
+
+	loc := time.UTC
+	if dsn, ok := (*params)[ParamDestinationDsn].(*mysql.Config); ok && dsn.Loc != nil {
+		loc = dsn.Loc
+	}
+
+	// Unknown methods fall back on REPLACE, as with the DefaultLoader
+	modifier := "REPLACE"
+	if method == "INSERT" {
+		modifier = "IGNORE"
+	}
+	statement := new(bytes.Buffer)
+	statement.WriteString("LOAD DATA LOCAL INFILE 'Reader::")
+	name := fmt.Sprintf("migrator-bulk-%d", bulkReaders.Add(1))
+	statement.WriteString(name)
+	statement.WriteString("' " + modifier + " INTO TABLE `" + table + "`")
+	statement.WriteString(" CHARACTER SET " + paramString(*params, ParamBulkCharacterSet, "utf8mb4"))
+	statement.WriteString(" FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\' LINES TERMINATED BY '\\n' ( ")
+	for i, col := range columns {
+		if i > 0 {
+			statement.WriteString(", ")
+		}
+		statement.WriteString("`" + col + "`")
+	}
+	statement.WriteString(" )")
+
+	if debug {
+		logger.Debugf(tag+"Streaming %d rows: %s", len(rows), statement.String())
+	}
+
+	// Rows are encoded as the driver reads them, rather than buffered
+	reader, writer := io.Pipe()
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		writer.CloseWithError(writeBulkRows(writer, columns, rows, loc))
+	}()
+	mysql.RegisterReaderHandler(name, func() io.Reader { return reader })
+	defer mysql.DeregisterReaderHandler(name)
+
+	_, err := tx.ExecContext(ctx, statement.String())
+	// Unblock the writer if the driver stopped reading early
+	reader.Close()
+	<-written
+	if err != nil {
+		logger.Errorf(tag+"ERROR: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// writeBulkRows writes rows as tab separated lines of their columns, in
+// the format read by LOAD DATA with its default field and line options.
+func writeBulkRows(w io.Writer, columns []string, rows []SQLUntypedRow, loc *time.Location) error {
+	buf := bufio.NewWriter(w)
+	field := make([]byte, 0, 64)
+	for _, row := range rows {
+		for i, col := range columns {
+			if i > 0 {
+				buf.WriteByte('\t')
+			}
+			var err error
+			field, err = appendBulkValue(field[:0], row[col], loc)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", col, err)
+			}
+			buf.Write(field)
+		}
+		err := buf.WriteByte('\n')
+		if err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// appendBulkValue appends a value encoded as a LOAD DATA field: NULL as
+// \N, times in the location of the destination connection as the driver
+// would send them, and strings and binary values byte for byte with the
+// field and line terminators, the escape character and NUL escaped.
+func appendBulkValue(b []byte, v any, loc *time.Location) ([]byte, error) {
+	if valuer, ok := v.(driver.Valuer); ok {
+		var err error
+		v, err = valuer.Value()
+		if err != nil {
+			return b, err
+		}
+	}
+	switch v := v.(type) {
+	case nil:
+		return append(b, '\\', 'N'), nil
+	case []byte:
+		return appendBulkEscaped(b, v), nil
+	case sql.RawBytes:
+		return appendBulkEscaped(b, v), nil
+	case string:
+		return appendBulkEscaped(b, []byte(v)), nil
+	case time.Time:
+		if v.IsZero() {
+			return append(b, "0000-00-00 00:00:00"...), nil
+		}
+		return v.In(loc).AppendFormat(b, "2006-01-02 15:04:05.999999"), nil
+	case bool:
+		if v {
+			return append(b, '1'), nil
+		}
+		return append(b, '0'), nil
+	case int64:
+		return strconv.AppendInt(b, v, 10), nil
+	case int:
+		return strconv.AppendInt(b, int64(v), 10), nil
+	case uint64:
+		return strconv.AppendUint(b, v, 10), nil
+	case float64:
+		return strconv.AppendFloat(b, v, 'g', -1, 64), nil
+	case float32:
+		return strconv.AppendFloat(b, float64(v), 'g', -1, 32), nil
+	default:
+		return appendBulkEscaped(b, []byte(fmt.Sprint(v))), nil
+	}
+}
+
+// appendBulkEscaped appends bytes escaped for a LOAD DATA field which is
+// escaped by a backslash.
+func appendBulkEscaped(b []byte, v []byte) []byte {
+	for _, c := range v {
+		switch c {
+		case '\\':
+			b = append(b, '\\', '\\')
+		case '\t':
+			b = append(b, '\\', 't')
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case 0:
+			b = append(b, '\\', '0')
+		case 0x1a:
+			b = append(b, '\\', 'Z')
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
@@ -1,72 +1,142 @@
 package migrator
 
 import (
+	"context"
 	"database/sql"
+	"strings"
 	"time"
 )
 
-// DefaultLoader represents a default Loader instance.
-var DefaultLoader = func(db *sql.DB, tables []TableData, params *Parameters) error {
-	var err error
+func init() {
+	LoaderMap["default"] = DefaultLoader
+}
+
+// DefaultLoader represents a default Loader instance. All tables and
+// methods are loaded within a single transaction, which also updates the
+// tracking table, so that the tracked position only advances when the data
+// has been committed. Rows which are not being removed are loaded with
+// ParamLoadMethod, if it is set, rather than the method they were
+// extracted with.
+var DefaultLoader = func(ctx context.Context, db *sql.DB, tables []TableData, ts TrackingStatus, params *Parameters) error {
+	return loadTransaction(ctx, db, "DefaultLoader", tables, ts, params, loadBatched)
+}
+
+// methodLoader loads rows which share a method into a table within the
+// transaction of a Loader.
+type methodLoader func(ctx context.Context, tx *sql.Tx, table string, method string, rows []SQLUntypedRow, params *Parameters) error
 
-	size := paramInt(*params, ParamInsertBatchSize, 100)
+// loadTransaction loads all tables within a single transaction, grouping
+// the rows of each table by method and passing each group to load, then
+// updates the tracking position within the same transaction before
+// committing it.
+func loadTransaction(ctx context.Context, db *sql.DB, name string, tables []TableData, ts TrackingStatus, params *Parameters, load methodLoader) error {
+	loadMethod := strings.ToUpper(paramString(*params, ParamLoadMethod, ""))
 	//debug := paramBool(*params, ParamDebug, false)
 
+	tag := name + "(" + ts.SourceDatabase + "." + ts.SourceTable + "): "
+
+	logger.Debugf(tag+"Beginning transaction, InsertBatchSize == %d", paramInt(*params, ParamInsertBatchSize, DefaultInsertBatchSize))
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Errorf(tag+"Transaction start: %s", err.Error())
+		return err
+	}
+
 	for _, table := range tables {
-		tag := "DefaultLoader(" + table.DbName + "." + table.TableName + "): "
+		tag := name + "(" + table.DbName + "." + table.TableName + "): "
 		tsStart := time.Now()
 
-		// Batch into transaction methods
+		// Batch into transaction methods, which are loaded in the order in
+		// which they first appear
 		rowsByMethod := make(map[string][]SQLUntypedRow, 0)
+		methods := make([]string, 0, 1)
 		for _, r := range table.Data {
-			if _, ok := rowsByMethod[r.Method]; !ok {
-				rowsByMethod[r.Method] = make([]SQLUntypedRow, 0)
+			method := r.Method
+			if loadMethod != "" && method != "REMOVE" {
+				method = loadMethod
+			}
+			if _, ok := rowsByMethod[method]; !ok {
+				rowsByMethod[method] = make([]SQLUntypedRow, 0)
+				methods = append(methods, method)
 			}
-			rowsByMethod[r.Method] = append(rowsByMethod[r.Method], r.Data)
+			rowsByMethod[method] = append(rowsByMethod[method], r.Data)
 		}
 
-		for method := range rowsByMethod {
-			logger.Debugf(tag+"Beginning transaction, InsertBatchSize == %d", size)
-			tx, err := db.Begin()
+		for _, method := range methods {
+			err = load(ctx, tx, table.TableName, method, rowsByMethod[method], params)
 			if err != nil {
-				logger.Errorf(tag + "Transaction start: " + err.Error())
-				return err
-			}
-			switch method {
-			case "REPLACE":
-				logger.Debug(tag + "Method REPLACE")
-				err = BatchedReplace(tx, table.TableName, rowsByMethod[method], size, params)
-
-			case "INSERT":
-				logger.Debug(tag + "Method INSERT")
-				err = BatchedInsert(tx, table.TableName, rowsByMethod[method], size, params)
-
-			case "REMOVE":
-				logger.Debug(tag + "Method REMOVE")
-				err = BatchedRemove(tx, table.TableName, rowsByMethod[method], size, params)
-
-			default:
-				logger.Debugf(tag+"Unknown method '%s' present, falling back on REPLACE", method)
-				err = BatchedReplace(tx, table.TableName, rowsByMethod[method], size, params)
-			}
-			if err != nil {
-				logger.Warnf(tag + "Rolling back transaction")
-				err2 := tx.Rollback()
-				if err2 != nil {
-					logger.Errorf(tag + "Error during rollback: " + err2.Error())
-				}
+				rollbackTransaction(tag, tx)
 				return err
 			}
+		}
 
-			logger.Infof(tag+"Duration to insert %d rows: %s", len(table.Data), time.Since(tsStart).String())
+		logger.Infof(tag+"Duration to insert %d rows: %s", len(table.Data), time.Since(tsStart).String())
+	}
 
-			logger.Debugf(tag + "Committing transaction")
-			err = tx.Commit()
-			if err != nil {
-				logger.Errorf(tag + "Error during commit: " + err.Error())
-			}
+	afterCommit := func() error { return nil }
+	if ts.SourceTable != "" {
+		logger.Debug(tag + "Updating tracking table")
+		afterCommit, err = serializeTrackingStatusTx(db, tx, ts)
+		if err != nil {
+			logger.Errorf(tag+"Tracking: %s", err.Error())
+			rollbackTransaction(tag, tx)
+			return err
 		}
 	}
 
-	return err
+	logger.Debug(tag + "Committing transaction")
+	err = tx.Commit()
+	if err != nil {
+		logger.Errorf(tag+"Error during commit: %s", err.Error())
+		return err
+	}
+
+	// Tracking stores outside of the destination database are updated once
+	// the data has been committed. A failure here only means that the
+	// position is persisted with the next batch.
+	err = afterCommit()
+	if err != nil {
+		logger.Errorf(tag+"Tracking: %s", err.Error())
+	}
+
+	return nil
+}
+
+// loadBatched loads rows into a table with batched statements of
+// ParamInsertBatchSize rows, falling back on REPLACE for unknown methods.
+func loadBatched(ctx context.Context, tx *sql.Tx, table string, method string, rows []SQLUntypedRow, params *Parameters) error {
+	size := paramInt(*params, ParamInsertBatchSize, DefaultInsertBatchSize)
+	tag := "loadBatched(" + table + "): "
+
+	switch method {
+	case "REPLACE":
+		logger.Debug(tag + "Method REPLACE")
+		return BatchedReplace(ctx, tx, table, rows, size, params)
+
+	case "INSERT":
+		logger.Debug(tag + "Method INSERT")
+		return BatchedInsert(ctx, tx, table, rows, size, params)
+
+	case "UPSERT":
+		logger.Debug(tag + "Method UPSERT")
+		return BatchedUpsert(ctx, tx, table, rows, size, params)
+
+	case "REMOVE":
+		logger.Debug(tag + "Method REMOVE")
+		return BatchedRemove(ctx, tx, table, rows, size, params)
+
+	default:
+		logger.Debugf(tag+"Unknown method '%s' present, falling back on REPLACE", method)
+		return BatchedReplace(ctx, tx, table, rows, size, params)
+	}
+}
+
+// rollbackTransaction rolls back a failed loader transaction, logging any
+// error encountered during the rollback.
+func rollbackTransaction(tag string, tx *sql.Tx) {
+	logger.Warn(tag + "Rolling back transaction")
+	err := tx.Rollback()
+	if err != nil {
+		logger.Errorf(tag+"Error during rollback: %s", err.Error())
+	}
 }
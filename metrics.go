@@ -0,0 +1,97 @@
+package migrator
+
+import (
+	"time"
+)
+
+const (
+	// StageExtractor identifies the Extractor stage of an Iteration
+	StageExtractor = "Extractor"
+	// StageTransformer identifies the Transformer stage of an Iteration
+	StageTransformer = "Transformer"
+	// StageLoader identifies the Loader stage of an Iteration
+	StageLoader = "Loader"
+	// StageTracking identifies retrieval of the TrackingStatus for an
+	// Iteration
+	StageTracking = "Tracking"
+	// StageDeadLetter identifies replaying of dead lettered batches for
+	// an Iteration
+	StageDeadLetter = "DeadLetter"
+	// StageLag identifies lag checks for an Iteration
+	StageLag = "Lag"
+	// StageSnapshot identifies the copying of a chunk of the initial
+	// snapshot of an Iteration
+	StageSnapshot = "Snapshot"
+	// StageBackfill identifies the copying of a batch of a range of keys
+	// by a backfill of an Iteration
+	StageBackfill = "Backfill"
+)
+
+// MetricsCollector receives instrumentation from a running Migrator. All
+// methods are called from the goroutines running the Iteration identified
+// by the TrackingKey, and must be safe for concurrent use. Implementations
+// should not block.
+type MetricsCollector interface {
+	// ObserveStage is called once each stage of a batch has completed,
+	// with the time taken and any error encountered.
+	ObserveStage(key TrackingKey, stage string, duration time.Duration, err error)
+
+	// ObserveRows is called with the number of rows processed by a stage,
+	// broken down by method ( INSERT, REPLACE, REMOVE ). Rows are reported
+	// for StageExtractor once extracted and for StageLoader once loaded.
+	ObserveRows(key TrackingKey, stage string, method string, count int)
+
+	// ObservePosition is called with the TrackingStatus of an Iteration
+	// each time its position is retrieved or advanced.
+	ObservePosition(key TrackingKey, ts TrackingStatus)
+}
+
+// observeStage reports the duration of a stage to the MetricsCollector, if
+// one is configured.
+func (m *Migrator) observeStage(x int, stage string, start time.Time, err error) {
+	if m.Metrics == nil {
+		return
+	}
+	m.Metrics.ObserveStage(m.trackingKey(x), stage, time.Since(start), err)
+}
+
+// observeExtracted reports extracted rows, by method, to the
+// MetricsCollector, if one is configured.
+func (m *Migrator) observeExtracted(x int, rows []SQLRow) {
+	if m.Metrics == nil {
+		return
+	}
+	counts := map[string]int{}
+	for _, r := range rows {
+		counts[r.Method]++
+	}
+	for method, count := range counts {
+		m.Metrics.ObserveRows(m.trackingKey(x), StageExtractor, method, count)
+	}
+}
+
+// observeLoaded reports loaded rows, by method, to the MetricsCollector, if
+// one is configured.
+func (m *Migrator) observeLoaded(x int, data []TableData) {
+	if m.Metrics == nil {
+		return
+	}
+	counts := map[string]int{}
+	for _, t := range data {
+		for _, r := range t.Data {
+			counts[r.Method]++
+		}
+	}
+	for method, count := range counts {
+		m.Metrics.ObserveRows(m.trackingKey(x), StageLoader, method, count)
+	}
+}
+
+// observePosition reports the TrackingStatus of an Iteration to the
+// MetricsCollector, if one is configured.
+func (m *Migrator) observePosition(x int, ts TrackingStatus) {
+	if m.Metrics == nil {
+		return
+	}
+	m.Metrics.ObservePosition(m.trackingKey(x), ts)
+}
@@ -1,8 +1,10 @@
 package migrator
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"math/rand"
 	"sync"
 	"time"
@@ -10,11 +12,17 @@ import (
 	"github.com/go-sql-driver/mysql"
 	log "github.com/sirupsen/logrus"
 	"go.elastic.co/apm/module/apmsql"
+	"golang.org/x/time/rate"
 )
 
 var (
 	// logger represents the logger used by the migrator.
 	logger *log.Logger
+
+	// ErrFatal can be wrapped by errors returned from an Extractor or
+	// Loader to indicate that retrying is pointless. The Migrator is
+	// stopped, and the error is returned by Wait().
+	ErrFatal = errors.New("fatal error")
 )
 
 // SetLogger sets a logrus Logger object used by the migrator
@@ -49,13 +57,38 @@ type Migrator struct {
 	// ErrorCallback represents a logging callback for errors
 	ErrorCallback func(map[string]string, error)
 
+	// AlertCallback is called when an Iteration falls further behind its
+	// source than its ParamLagThreshold or ParamLagRowThreshold. If it is
+	// not set, the ErrorCallback is called with ErrLagExceeded instead.
+	AlertCallback func(map[string]string, IterationLag)
+
+	// Metrics receives instrumentation for each Iteration, if set.
+	Metrics MetricsCollector
+
+	// TrackingStore determines where TrackingStatus objects are persisted.
+	// If not set, a MySQLTrackingStore using the destination database is
+	// used. A TrackingStore set here is not closed by the Migrator.
+	TrackingStore TrackingStore
+
 	// Internal fields
 
 	sourceDb      *sql.DB
 	destinationDb *sql.DB
-	initialized   bool
-	state         MigratorState
+	trackingStore TrackingStore
 	wg            *sync.WaitGroup
+	rowLimiter    *rate.Limiter
+	queryLimiter  *rate.Limiter
+	health        *sourceHealth
+
+	// mutex guards the lifecycle fields below, which are shared between
+	// the goroutines started by Run() and the caller
+	mutex        sync.Mutex
+	initialized  bool
+	state        MigratorState
+	stateChanged chan struct{}
+	cancel       context.CancelFunc
+	done         chan struct{}
+	err          error
 }
 
 // Iteration defines the individual sub-migrator configuration which replicates
@@ -69,6 +102,11 @@ type Iteration struct {
 	// for the Extractor.
 	SourceTable string
 
+	// ID optionally distinguishes Iterations which read from the same
+	// source table into the same destination table ( for example, using
+	// different Transformers ), so that each maintains its own position.
+	ID string
+
 	// SourceKey is the key field which is used to determine position.
 	// This is only specified for the creation of the tracking
 	// table if necessary.
@@ -99,39 +137,79 @@ type Iteration struct {
 
 	// Loader represents the Loader callback.
 	Loader Loader
+
+	// Internal fields
+
+	// params and transformerParams are the working copies of Parameters
+	// and TransformerParameters used by the goroutine running the
+	// Iteration, which Extractors and adaptive batch sizing modify, so
+	// that Parameters is not modified while it is read by the other
+	// goroutines of a running Migrator.
+	params            *Parameters
+	transformerParams *Parameters
+
+	deadLetter   *PersistenceQueue
+	status       *iterationStatus
+	sizer        *batchSizer
+	retry        *retryPolicy
+	rowLimiter   *rate.Limiter
+	queryLimiter *rate.Limiter
 }
 
-// SetWaitGroup sets the wait group instance being used
+// SetWaitGroup sets the wait group instance being used. A running
+// Migrator holds a single entry in the wait group until it has stopped and
+// closed its connections.
 func (m *Migrator) SetWaitGroup(wg *sync.WaitGroup) {
 	m.wg = wg
 }
 
 // State returns the current state of the migrator
-func (m Migrator) State() MigratorState {
+func (m *Migrator) State() MigratorState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	return m.state
 }
 
 // SetState sets the current state of the migrator
 func (m *Migrator) SetState(s MigratorState) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.setState(s)
+}
+
+// setState changes the state of the migrator and wakes anything waiting
+// for a state change. The mutex must be held by the caller.
+func (m *Migrator) setState(s MigratorState) {
 	m.state = s
+	if m.stateChanged != nil {
+		close(m.stateChanged)
+	}
+	m.stateChanged = make(chan struct{})
 }
 
 // Pause will "pause" the migrator
 func (m *Migrator) Pause() error {
 	tag := "Migrator.Pause(): [" + m.SourceDsn.DBName + "] "
 
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	// Make sure this is quiet and non-desctructive if called when already paused
 	if m.state == S_PAUSED {
 		return nil
 	}
 
 	if !m.initialized {
-		m.state = S_STOPPED
+		m.setState(S_STOPPED)
 		return nil
 	}
 
-	logger.Infof(tag + "Pausing")
-	m.state = S_PAUSED
+	if m.state == S_STOPPING || m.state == S_STOPPED {
+		return errors.New(tag + "Unable to pause in state " + m.state.String())
+	}
+
+	logger.Info(tag + "Pausing")
+	m.setState(S_PAUSED)
 
 	return nil
 }
@@ -140,45 +218,41 @@ func (m *Migrator) Pause() error {
 func (m *Migrator) Unpause() error {
 	tag := "Migrator.Unpause(): [" + m.SourceDsn.DBName + "] "
 
+	m.mutex.Lock()
 	// Make sure this is quiet and non-desctructive if called when already unpaused
 	if m.state == S_RUNNING {
+		m.mutex.Unlock()
 		return nil
 	}
 
 	if !m.initialized {
+		m.mutex.Unlock()
 		return m.Init()
 	}
+	defer m.mutex.Unlock()
 
-	logger.Infof(tag + "Unpausing")
-	m.state = S_RUNNING
-
-	return nil
-}
-
-/*
-// Pause will "pause" the migrator -- old method, should NOT be used
-func (m *Migrator) OldPause(p bool) error {
-	tag := "Migrator.OldPause(): [" + m.SourceDsn.DBName + "] "
-
-	if !m.initialized {
-		m.state = S_STOPPED
-		return errors.New(tag + "Not initialized")
+	if m.state != S_PAUSED {
+		return errors.New(tag + "Unable to unpause in state " + m.state.String())
 	}
 
-	logger.Infof(tag + "Sending quit signal")
-	m.state = S_STOPPING
+	logger.Info(tag + "Unpausing")
+	m.setState(S_RUNNING)
 
 	return nil
 }
-*/
 
 // SetErrorCallback sets the error callback function
 func (m *Migrator) SetErrorCallback(f func(map[string]string, error)) {
 	m.ErrorCallback = f
 }
 
+// SetAlertCallback sets the lag alert callback function
+func (m *Migrator) SetAlertCallback(f func(map[string]string, IterationLag)) {
+	m.AlertCallback = f
+}
+
 // GetWaitGroup returns the wait group instance being used
-func (m Migrator) GetWaitGroup() *sync.WaitGroup {
+func (m *Migrator) GetWaitGroup() *sync.WaitGroup {
 	return m.wg
 }
 
@@ -188,13 +262,16 @@ func (m *Migrator) Init() error {
 	tag := "Migrator.Init(): [" + m.SourceDsn.FormatDSN() + "] "
 
 	var err error
-	logger.Infof(tag + "Initializing migrator")
+	logger.Info(tag + "Initializing migrator")
 
 	if m.SourceDsn == nil || m.DestinationDsn == nil {
 		return errors.New(tag + "No source or destination DSN set")
 	}
 
-	if m.initialized {
+	m.mutex.Lock()
+	initialized := m.initialized
+	m.mutex.Unlock()
+	if initialized {
 		return errors.New(tag + "Already initialized")
 	}
 
@@ -213,7 +290,11 @@ func (m *Migrator) Init() error {
 		return err
 	}
 	m.sourceDb.SetMaxIdleConns(0)
-	m.sourceDb.SetMaxOpenConns(len(m.Iterations) * 3)
+	sourceConns := len(m.Iterations) * 3
+	for x := range m.Iterations {
+		sourceConns += snapshotConnections(m.Iterations[x].Parameters) + backfillConnections(m.Iterations[x].Parameters)
+	}
+	m.sourceDb.SetMaxOpenConns(sourceConns)
 
 	logger.Infof(tag+"Using destination dsn: %s", m.DestinationDsn.FormatDSN())
 	if m.Apm {
@@ -228,35 +309,81 @@ func (m *Migrator) Init() error {
 	m.destinationDb.SetMaxIdleConns(0)
 	m.destinationDb.SetMaxOpenConns(len(m.Iterations) * 3)
 
+	m.trackingStore = m.TrackingStore
+	if m.trackingStore == nil {
+		m.trackingStore = NewMySQLTrackingStore(m.destinationDb)
+	}
+
+	// Attempt to make sure there is a tracking table
+	logger.Info(tag + "Initializing tracking store")
+	err = m.trackingStore.Init()
+	if err != nil {
+		return err
+	}
+
+	// Loaders split statements which would exceed max_allowed_packet
+	maxPacket, err := destinationMaxPacket(m.destinationDb, m.DestinationDsn)
+	if err != nil {
+		logger.Warnf(tag+"Unable to determine max_allowed_packet, assuming %d bytes: %s", DefaultMaxAllowedPacket, err.Error())
+		maxPacket = DefaultMaxAllowedPacket
+	}
+
+	// Loaders look up the primary key of each destination table once
+	primaryKeys := newPrimaryKeyCache()
+
 	for x := range m.Iterations {
 
+		if m.Iterations[x].Parameters == nil {
+			m.Iterations[x].Parameters = &Parameters{}
+		}
+		// Batches extracted ahead of a failed load are discarded and
+		// extracted again, which would lose rows removed from their source
+		if paramBool(*m.Iterations[x].Parameters, ParamPipeline, false) && consumingExtractors[m.Iterations[x].ExtractorName] {
+			return errors.New(tag + "Extractor '" + m.Iterations[x].ExtractorName + "' does not support pipelining")
+		}
+		if _, ok := (*m.Iterations[x].Parameters)[ParamMaxAllowedPacket]; !ok {
+			(*m.Iterations[x].Parameters)[ParamMaxAllowedPacket] = maxPacket
+		}
+		(*m.Iterations[x].Parameters)[paramPrimaryKeys] = primaryKeys
+
 		// Avoid NPEs and just pass basic params if there are no TransformerParameters
 		if m.Iterations[x].TransformerParameters == nil {
 			m.Iterations[x].TransformerParameters = m.Iterations[x].Parameters
 		}
 
-		// Attempt to make sure there is a tracking table and status entry
+		// Extractors which maintain their own source connections need the
+		// source DSN, and loaders need the settings of the destination
+		(*m.Iterations[x].Parameters)[ParamSourceDsn] = m.SourceDsn
+		(*m.Iterations[x].Parameters)[ParamDestinationDsn] = m.DestinationDsn
 
-		logger.Infof(tag + "Ensuring that tracking table exists")
-		err = CreateTrackingTable(m.destinationDb)
-		if err != nil {
-			return err
+		if path := paramString(*m.Iterations[x].Parameters, ParamDeadLetterPath, ""); path != "" && m.Iterations[x].deadLetter == nil {
+			logger.Infof(tag+"Opening dead letter queue for %s in %s", m.trackingKey(x).String(), path)
+			pq, err := OpenDeadLetterQueue(path, m.trackingKey(x))
+			if err != nil {
+				return err
+			}
+			m.Iterations[x].deadLetter = &pq
 		}
 
-		logger.Infof(tag+"Getting tracking table status for %s.%s", m.SourceDsn.DBName, m.Iterations[x].SourceTable)
-		_, err = GetTrackingStatus(m.destinationDb, m.SourceDsn.DBName, m.Iterations[x].SourceTable)
+		// Attempt to make sure there is a tracking status entry
+
+		logger.Infof(tag+"Getting tracking table status for %s", m.trackingKey(x).String())
+		_, err = m.trackingStore.Get(m.trackingKey(x))
 		if err != nil {
 			tt := TrackingStatus{
 				Db:                 m.destinationDb,
+				Store:              m.trackingStore,
 				SourceDatabase:     m.SourceDsn.DBName,
 				SourceTable:        m.Iterations[x].SourceTable,
+				DestinationTable:   m.Iterations[x].DestinationTable,
+				IterationID:        m.Iterations[x].ID,
 				ColumnName:         m.Iterations[x].SourceKey,
 				SequentialPosition: 0,
 				TimestampPosition:  NullTime{},
 				LastRun:            NullTimeNow(),
 			}
 			logger.Infof(tag+"Creating tracking table entry, as none exists: %#v", tt)
-			err := SerializeNewTrackingStatus(tt)
+			err := m.trackingStore.Create(tt)
 			if err != nil {
 				logger.Infof(tag+"TrackingStatus: %#v", tt)
 				return err
@@ -264,24 +391,34 @@ func (m *Migrator) Init() error {
 		}
 	}
 
+	m.initRateLimits()
+
+	m.mutex.Lock()
 	m.initialized = true
+	m.mutex.Unlock()
 
 	return nil
 }
 
-// sleepWithInterrupt allows a sleep cycle that checks for termination every second
-func (m *Migrator) sleepWithInterrupt(length int) {
-	for i := 0; i <= length; i++ {
-		time.Sleep(time.Second)
-		if m.state == S_STOPPING {
-			return
-		}
+// sleepWithInterrupt sleeps for the specified duration, returning false
+// early if the context is cancelled.
+func sleepWithInterrupt(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
 	}
 }
 
-// Run spins off a goroutine with a running migrator until the corresponding
-// Quit() method is called.
-func (m *Migrator) Run() error {
+// Run spins off goroutines running each Iteration of the migrator until
+// the corresponding Quit() method is called, the context is cancelled or
+// an Iteration fails with a fatal error. The context is passed to all
+// Extractor and Loader calls, so cancelling it interrupts any running
+// queries. Wait() can be used to block until the migrator has stopped.
+func (m *Migrator) Run(ctx context.Context) error {
 	debug := paramBool(*(m.Parameters), ParamDebug, false)
 	if debug {
 		logger.Level = log.TraceLevel
@@ -289,193 +426,601 @@ func (m *Migrator) Run() error {
 
 	tag := "Migrator.Run(): [" + m.SourceDsn.DBName + "] "
 
-	logger.Debugf(tag + "Entry")
+	logger.Debug(tag + "Entry")
 
-	if !m.initialized && m.state != S_STOPPED && m.state != S_STOPPING {
-		return errors.New(tag + "Not initialized or in S_STOPPING|S_STOPPED state")
+	m.mutex.Lock()
+	if !m.initialized {
+		m.mutex.Unlock()
+		return errors.New(tag + "Not initialized")
+	}
+	if m.cancel != nil {
+		m.mutex.Unlock()
+		return errors.New(tag + "Already running")
+	}
+	if m.state == S_STOPPING || m.state == S_STOPPED {
+		m.mutex.Unlock()
+		return errors.New(tag + "Unable to run in state " + m.state.String())
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	m.cancel = cancel
+	m.done = done
+	m.err = nil
 	if m.state != S_PAUSED {
 		// Don't attempt to set state to be "RUNNING" if we started a pause at the beginning
-		m.state = S_RUNNING
+		m.setState(S_RUNNING)
 	}
+	m.mutex.Unlock()
 
 	for x := range m.Iterations {
-		delay := paramInt(*m.Iterations[x].Parameters, ParamSleepBetweenRuns, 5)
+		m.initWorkingParams(x)
+	}
 
-		m.wg.Add(1)
+	var running sync.WaitGroup
+	for x := range m.Iterations {
+		if m.Iterations[x].deadLetter != nil {
+			running.Add(1)
+			go func(x int) {
+				defer running.Done()
+				m.retryDeadLetters(ctx, x)
+			}(x)
+		}
+		if paramInt(*m.Iterations[x].Parameters, ParamLagThreshold, 0) > 0 || paramInt(*m.Iterations[x].Parameters, ParamLagRowThreshold, 0) > 0 {
+			running.Add(1)
+			go func(x int) {
+				defer running.Done()
+				m.monitorLag(ctx, x)
+			}(x)
+		}
+
+		m.iterationStatus(x).start()
+		running.Add(1)
 		go func(x int) {
-			// Actual run
-			var ts TrackingStatus
-			var err error
-			var attempt int
-			for {
-				ts, err = GetTrackingStatus(m.destinationDb, m.SourceDsn.DBName, m.Iterations[x].SourceTable)
-				if err != nil {
-					logger.Warnf(tag+"GetTrackingStatus[Attempt %d, state=%s]: %s", attempt, m.state, err.Error())
-					attempt++
-					m.sleepWithInterrupt(delay)
-					if m.state == S_STOPPING || m.state == S_STOPPED {
-						logger.Infof(tag+"Received status %s", m.state.String())
-						m.Close()
-						m.wg.Done()
-						return
-					}
-					continue
-				}
-				break
+			defer running.Done()
+			m.runIteration(ctx, x)
+		}(x)
+	}
+
+	if m.wg != nil {
+		m.wg.Add(1)
+	}
+	go func() {
+		running.Wait()
+		m.Close()
+
+		m.mutex.Lock()
+		cancel()
+		m.cancel = nil
+		if m.state != S_TERMINATED {
+			m.setState(S_STOPPED)
+		}
+		close(done)
+		m.mutex.Unlock()
+
+		logger.Info(tag + "Stopped")
+		if m.wg != nil {
+			m.wg.Done()
+		}
+	}()
+
+	return nil
+}
+
+// initWorkingParams copies the Parameters of an Iteration for the goroutine
+// running it. Transformers which share the Parameters of the Iteration
+// share the copy, so that they still see the ParamMethod set by the
+// Extractor.
+func (m *Migrator) initWorkingParams(x int) {
+	m.Iterations[x].params = cloneParams(m.Iterations[x].Parameters)
+	m.Iterations[x].transformerParams = m.Iterations[x].TransformerParameters
+	if m.Iterations[x].TransformerParameters == m.Iterations[x].Parameters {
+		m.Iterations[x].transformerParams = m.Iterations[x].params
+	}
+}
+
+// Wait blocks until a running migrator has stopped and closed its
+// connections, and returns the first fatal error encountered by any of its
+// Iterations, or nil if it was stopped by Quit() or by cancelling the
+// context passed to Run().
+func (m *Migrator) Wait() error {
+	m.mutex.Lock()
+	done := m.done
+	m.mutex.Unlock()
+	if done == nil {
+		return nil
+	}
+	<-done
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.err
+}
+
+// fail records a fatal error and stops the migrator. Only the first fatal
+// error is retained.
+func (m *Migrator) fail(err error) {
+	m.mutex.Lock()
+	if m.err == nil {
+		m.err = err
+	}
+	if m.state != S_STOPPED && m.state != S_TERMINATED {
+		m.setState(S_STOPPING)
+	}
+	cancel := m.cancel
+	m.mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// runIteration repeatedly extracts, transforms and loads data for an
+// Iteration until the context is cancelled.
+func (m *Migrator) runIteration(ctx context.Context, x int) {
+	tag := "Migrator.runIteration(): [" + m.trackingKey(x).String() + "] "
+	delay := time.Duration(paramInt(*m.Iterations[x].Parameters, ParamSleepBetweenRuns, 5)) * time.Second
+
+	status := m.iterationStatus(x)
+	status.setState(I_RUNNING)
+	m.initBatchSize(x)
+	m.initRetry(x)
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf(tag+"Panic: %v", r)
+			err := fmt.Errorf("%s: panic: %v", m.trackingKey(x).String(), r)
+			status.setError(err)
+			status.setState(I_ERRORED)
+			m.fail(err)
+		} else {
+			status.mutex.Lock()
+			if status.state != I_ERRORED {
+				status.state = I_STOPPED
+				status.notify()
 			}
-			logger.Debugf(tag + "Entering loop")
-			for {
-				if m.state == S_PAUSED {
-					logger.Infof(tag+"Recevied state %s", m.state.String())
-					for {
-						time.Sleep(time.Second * 2)
-
-						// Check for non-pause
-						if m.state != S_PAUSED {
-							break
-						}
-					}
-				}
+			status.mutex.Unlock()
+		}
+		// A rewind requested while stopping would otherwise be lost
+		if position := status.stop(); position != nil {
+			err := m.rewindStoredPosition(x, *position)
+			if err != nil {
+				logger.Errorf(tag+"Rewind: %s", err.Error())
+				status.setError(err)
+			}
+		}
+	}()
 
-				if m.state == S_STOPPING || m.state == S_STOPPED {
-					logger.Infof(tag+"Received state %s", m.state.String())
-					m.Close()
-					m.wg.Done()
+	ts, ok := m.waitForTrackingStatus(ctx, x, delay)
+	if !ok {
+		return
+	}
+
+	for phase := m.initialPhase(x, ts); phase != ""; {
+		if !m.waitWhilePaused(ctx, x) {
+			logger.Info(tag + "Stopping")
+			return
+		}
+		var copyTs TrackingStatus
+		var err error
+		stage := StageSnapshot
+		if phase == PhaseBackfill {
+			stage = StageBackfill
+			status.setState(I_BACKFILL)
+			copyTs, err = m.backfill(ctx, x, ts)
+		} else {
+			status.setState(I_SNAPSHOT)
+			copyTs, err = m.snapshot(ctx, x, ts)
+		}
+		if ctx.Err() != nil {
+			logger.Info(tag + "Stopping")
+			return
+		}
+		if err == nil {
+			ts = copyTs
+			m.retrySucceeded(x, stage)
+			m.observePosition(x, ts)
+			status.setState(I_RUNNING)
+			break
+		}
+		logger.Errorf(tag+"%s: %s", stage, err.Error())
+		status.setError(err)
+		if m.ErrorCallback != nil {
+			m.ErrorCallback(map[string]string{
+				"Stage":            stage,
+				"SourceDb":         m.SourceDsn.DBName,
+				"SourceTable":      m.Iterations[x].SourceTable,
+				"DestinationDb":    m.DestinationDsn.DBName,
+				"DestinationTable": m.Iterations[x].DestinationTable,
+			}, err)
+		}
+		if errors.Is(err, ErrFatal) {
+			status.setState(I_ERRORED)
+			m.fail(fmt.Errorf("%s: %s: %w", m.trackingKey(x).String(), phase, err))
+			return
+		}
+		if !m.backoff(ctx, x, stage) {
+			logger.Info(tag + "Stopping")
+			return
+		}
+		// A snapshot restarts from the beginning, while a backfill resumes
+		// from the progress it persisted
+		if phase == PhaseBackfill {
+			ts = copyTs
+		}
+		ts.Phase = phase
+	}
+
+	logger.Debug(tag + "Entering loop")
+	for {
+		if !m.waitWhilePaused(ctx, x) {
+			logger.Info(tag + "Stopping")
+			return
+		}
+		if position := status.takeRewind(); position != nil {
+			rewound := rewindTrackingStatus(ts, *position)
+			err := m.trackingStore.Update(rewound)
+			if err != nil {
+				logger.Errorf(tag+"Rewind: %s", err.Error())
+				status.setError(err)
+			} else {
+				logger.Infof(tag+"Rewound from %s to %s", ts.String(), rewound.String())
+				ts = rewound
+			}
+		}
+		logger.Debugf(tag+"TrackingStatus[state=%s]: %s", m.State().String(), ts.String())
+
+		if paramBool(*m.Iterations[x].Parameters, ParamPipeline, false) {
+			var more bool
+			ts, more, ok = m.runPipeline(ctx, x, ts)
+			if !ok {
+				return
+			}
+			if !more {
+				ts, ok = m.idle(ctx, x, delay)
+				if !ok {
 					return
 				}
-				logger.Debugf(tag+"TrackingStatus[state=%s]: %s", m.state.String(), ts.String())
-
-				more, rows, newTs, err := m.Iterations[x].Extractor(m.sourceDb, m.SourceDsn.DBName, m.Iterations[x].SourceTable, ts, m.Iterations[x].Parameters)
-				if err != nil {
-					logger.Infof(tag + "Extractor: " + err.Error())
-					if m.ErrorCallback != nil {
-						m.ErrorCallback(map[string]string{
-							"Stage":       "Extractor",
-							"SourceDb":    m.SourceDsn.DBName,
-							"SourceTable": m.Iterations[x].SourceTable,
-						}, err)
-					}
-				}
-				logger.Infof(tag+"[%s.%s] Extracted %d rows", m.SourceDsn.DBName, m.Iterations[x].SourceTable, len(rows))
-
-				logger.Debugf(tag+"Running transformer for %s.%s", m.SourceDsn.DBName, m.Iterations[x].SourceTable)
-				logger.Debugf(tag+"Transformer %#v (%s,%s,%#v,%#v)", m.Iterations[x].Transformer, m.DestinationDsn.DBName, m.Iterations[x].DestinationTable, rows, m.Iterations[x].TransformerParameters)
-				data := m.Iterations[x].Transformer(m.DestinationDsn.DBName, m.Iterations[x].DestinationTable, rows, m.Iterations[x].TransformerParameters)
-				logger.Tracef(tag+"Transformer put out %#v for data", data)
-				logger.Debugf(tag+"Running loader for %s.%s", m.SourceDsn.DBName, m.Iterations[x].SourceTable)
-				err = m.Iterations[x].Loader(m.destinationDb, data, m.Iterations[x].Parameters)
-				if err != nil {
-					logger.Errorf(tag + "Loader: " + err.Error())
-					if m.ErrorCallback != nil {
-						m.ErrorCallback(map[string]string{
-							"Stage":            "Loader",
-							"SourceDb":         m.SourceDsn.DBName,
-							"SourceTable":      m.Iterations[x].SourceTable,
-							"DestinationDb":    m.DestinationDsn.DBName,
-							"DestinationTable": m.Iterations[x].DestinationTable,
-						}, err)
-					}
-				}
+			}
+			continue
+		}
 
-				logger.Debugf(tag + "Tracking: Updating table")
-				err = SerializeTrackingStatus(m.destinationDb, newTs)
-				if err != nil {
-					logger.Errorf(tag + "Tracking: " + err.Error())
-				}
+		size := m.applyBatchSize(x)
+		tsBatch := time.Now()
+		more, rows, newTs, ok := m.extract(ctx, x, ts)
+		if !ok {
+			return
+		}
+		data := m.transform(x, rows)
+		loaded, ok := m.load(ctx, x, data, newTs, m.Iterations[x].params)
+		if !ok {
+			return
+		}
+		if loaded {
+			m.observeBatchSize(x, len(rows), size, time.Since(tsBatch))
+		}
+		if !loaded {
+			// Retain the previous position so that the batch is retried
+			logger.Warnf(tag+"Retaining tracking position %s", ts.String())
+			if !m.backoff(ctx, x, StageLoader) {
+				return
+			}
+			continue
+		}
 
-				ts = newTs
-
-				if !more {
-					logger.Infof(tag+"No more rows detected to process, sleeping for %d sec + random offset", delay)
-					m.sleepWithInterrupt(delay)
-					time.Sleep(time.Millisecond * (time.Duration(float64(delay*1000) * rand.Float64())))
-
-					attempt = 0
-					for {
-						ts, err = GetTrackingStatus(m.destinationDb, m.SourceDsn.DBName, m.Iterations[x].SourceTable)
-						if err != nil {
-							logger.Warnf(tag+"GetTrackingStatus[Attempt %d, state=%s]: %s", attempt, m.state, err.Error())
-							attempt++
-							m.sleepWithInterrupt(delay)
-							if m.state == S_STOPPING {
-								logger.Infof(tag + "S_STOPPING state")
-								m.Close()
-								m.wg.Done()
-								return
-							}
-							if m.state == S_STOPPED {
-								logger.Infof(tag + "S_STOPPED state")
-								m.Close()
-								m.wg.Done()
-								return
-							}
-							continue
-						}
-						break
-					}
+		ts = newTs
+		status.setBatch(len(rows))
+		m.observePosition(x, ts)
 
-				}
+		if !more {
+			ts, ok = m.idle(ctx, x, delay)
+			if !ok {
+				return
+			}
+		}
+
+		// Sleep for 150ms to avoid pileups
+		if !sleepWithInterrupt(ctx, time.Millisecond*150) {
+			return
+		}
+	}
+}
+
+// consumingExtractors are the extractors which remove rows from their
+// source as they extract them, so that a batch cannot be extracted again
+// from the same tracking position.
+var consumingExtractors = map[string]bool{
+	"queue": true,
+}
 
-				// Sleep for 150ms to avoid pileups
-				time.Sleep(time.Millisecond * 150)
+// extract runs the Extractor of an Iteration from a tracking position,
+// reporting any error. A failed extraction is retried from the same
+// position, backing off between attempts, and any rows it returned are
+// discarded, unless the Extractor has already removed them from its
+// source, in which case they are returned to be loaded. The returned
+// TrackingStatus is populated so that it can be passed to the Loader. It
+// returns false if the Iteration must stop.
+func (m *Migrator) extract(ctx context.Context, x int, ts TrackingStatus) (bool, []SQLRow, TrackingStatus, bool) {
+	tag := "Migrator.extract(): [" + m.trackingKey(x).String() + "] "
+	status := m.iterationStatus(x)
+
+	var more bool
+	var rows []SQLRow
+	var newTs TrackingStatus
+	for {
+		if !m.throttleExtract(ctx, x) {
+			logger.Info(tag + "Stopping")
+			return false, nil, ts, false
+		}
+
+		var err error
+		tsExtract := time.Now()
+		more, rows, newTs, err = m.Iterations[x].Extractor(ctx, m.sourceDb, m.SourceDsn.DBName, m.Iterations[x].SourceTable, ts, m.Iterations[x].params)
+		if ctx.Err() != nil {
+			logger.Info(tag + "Stopping")
+			return false, nil, ts, false
+		}
+		m.observeStage(x, StageExtractor, tsExtract, err)
+		if err == nil {
+			m.retrySucceeded(x, StageExtractor)
+			break
+		}
+		logger.Errorf(tag+"Extractor: %s", err.Error())
+		status.setError(err)
+		m.backoffBatchSize(x, err)
+		if m.ErrorCallback != nil {
+			m.ErrorCallback(map[string]string{
+				"Stage":       StageExtractor,
+				"SourceDb":    m.SourceDsn.DBName,
+				"SourceTable": m.Iterations[x].SourceTable,
+			}, err)
+		}
+		if errors.Is(err, ErrFatal) {
+			status.setState(I_ERRORED)
+			m.fail(fmt.Errorf("%s: extractor: %w", m.trackingKey(x).String(), err))
+			return false, nil, ts, false
+		}
+		if len(rows) > 0 && consumingExtractors[m.Iterations[x].ExtractorName] {
+			logger.Warnf(tag+"Loading %d rows extracted before the error, as they cannot be extracted again", len(rows))
+			more, newTs = true, ts
+			break
+		}
+		// Rows returned along with an error are otherwise discarded, as the
+		// tracking position may not cover them
+		if len(rows) > 0 {
+			logger.Warnf(tag+"Discarding %d rows extracted before the error", len(rows))
+		}
+		if !m.backoff(ctx, x, StageExtractor) || !m.waitWhilePaused(ctx, x) {
+			logger.Info(tag + "Stopping")
+			return false, nil, ts, false
+		}
+	}
+	m.observeExtracted(x, rows)
+	logger.Infof(tag+"[%s.%s] Extracted %d rows", m.SourceDsn.DBName, m.Iterations[x].SourceTable, len(rows))
+	if !m.throttleRows(ctx, x, len(rows)) {
+		logger.Info(tag + "Stopping")
+		return false, nil, ts, false
+	}
+
+	// The loader commits the new tracking position along with the data
+	newTs.Db = m.destinationDb
+	newTs.Store = m.trackingStore
+	newTs.DestinationTable = m.Iterations[x].DestinationTable
+	newTs.IterationID = m.Iterations[x].ID
+	newTs.Phase = ts.Phase
+	return more, rows, newTs, true
+}
+
+// transform runs the Transformer of an Iteration over extracted rows.
+func (m *Migrator) transform(x int, rows []SQLRow) []TableData {
+	tag := "Migrator.transform(): [" + m.trackingKey(x).String() + "] "
+	logger.Debugf(tag+"Running transformer for %s.%s", m.SourceDsn.DBName, m.Iterations[x].SourceTable)
+	logger.Debugf(tag+"Transformer %#v (%s,%s,%#v,%#v)", m.Iterations[x].Transformer, m.DestinationDsn.DBName, m.Iterations[x].DestinationTable, rows, m.Iterations[x].TransformerParameters)
+	tsTransform := time.Now()
+	data := m.Iterations[x].Transformer(m.DestinationDsn.DBName, m.Iterations[x].DestinationTable, rows, m.Iterations[x].transformerParams)
+	logger.Tracef(tag+"Transformer put out %#v for data", data)
+	m.observeStage(x, StageTransformer, tsTransform, nil)
+	return data
+}
+
+// load runs the Loader of an Iteration with the specified Parameters, which
+// commits the new tracking position along with the data, reporting any
+// error. A batch which fails with a transient error is retried as it was
+// extracted, backing off between attempts, as the Extractor may not be
+// able to extract the same rows again. A batch which fails with any other
+// error, or which is still failing when the circuit breaker opens, is
+// stored in the dead letter queue of the Iteration, if it has one, and
+// is otherwise left to be extracted again, unless the Extractor removed
+// it from its source, in which case it is retried as well. It returns
+// whether the tracking position has moved to newTs, and false if the
+// Iteration must stop.
+func (m *Migrator) load(ctx context.Context, x int, data []TableData, newTs TrackingStatus, params *Parameters) (bool, bool) {
+	tag := "Migrator.load(): [" + m.trackingKey(x).String() + "] "
+	status := m.iterationStatus(x)
+
+	for {
+		logger.Debugf(tag+"Running loader for %s.%s", m.SourceDsn.DBName, m.Iterations[x].SourceTable)
+		tsLoad := time.Now()
+		err := m.Iterations[x].Loader(ctx, m.destinationDb, data, newTs, params)
+		if err == nil {
+			m.observeStage(x, StageLoader, tsLoad, nil)
+			m.observeLoaded(x, data)
+			m.retrySucceeded(x, StageLoader)
+			return true, true
+		}
+		if ctx.Err() != nil {
+			logger.Info(tag + "Stopping")
+			return false, false
+		}
+		m.observeStage(x, StageLoader, tsLoad, err)
+		logger.Errorf(tag+"Loader: %s", err.Error())
+		status.setError(err)
+		m.backoffBatchSize(x, err)
+		if m.ErrorCallback != nil {
+			m.ErrorCallback(map[string]string{
+				"Stage":            StageLoader,
+				"SourceDb":         m.SourceDsn.DBName,
+				"SourceTable":      m.Iterations[x].SourceTable,
+				"DestinationDb":    m.DestinationDsn.DBName,
+				"DestinationTable": m.Iterations[x].DestinationTable,
+			}, err)
+		}
+		if errors.Is(err, ErrFatal) {
+			status.setState(I_ERRORED)
+			m.fail(fmt.Errorf("%s: loader: %w", m.trackingKey(x).String(), err))
+			return false, false
+		}
+		if !retryableError(err) {
+			if m.storeDeadLetter(x, data, newTs, err) {
+				return true, true
 			}
-		}(x)
+			if !consumingExtractors[m.Iterations[x].ExtractorName] {
+				return false, true
+			}
+		}
+		if !m.backoff(ctx, x, StageLoader) {
+			// Unless the context was cancelled, the circuit breaker has
+			// opened and the batch would otherwise be lost
+			if ctx.Err() == nil {
+				m.storeDeadLetter(x, data, newTs, err)
+			}
+			logger.Info(tag + "Stopping")
+			return false, false
+		}
+		if !m.waitWhilePaused(ctx, x) {
+			logger.Info(tag + "Stopping")
+			return false, false
+		}
 	}
+}
 
-	return nil
+// idle sleeps once an Iteration has found no more rows to process, then
+// retrieves its TrackingStatus again. It returns false if the context is
+// cancelled.
+func (m *Migrator) idle(ctx context.Context, x int, delay time.Duration) (TrackingStatus, bool) {
+	tag := "Migrator.idle(): [" + m.trackingKey(x).String() + "] "
+	jitter := time.Duration(float64(delay) * rand.Float64())
+	logger.Infof(tag+"No more rows detected to process, sleeping for %s + %s random offset", delay.String(), jitter.String())
+	if !m.iterationStatus(x).sleep(ctx, delay+jitter) {
+		return TrackingStatus{}, false
+	}
+	return m.waitForTrackingStatus(ctx, x, delay)
+}
+
+// waitForTrackingStatus retrieves the TrackingStatus for an Iteration,
+// retrying until it succeeds or the context is cancelled.
+func (m *Migrator) waitForTrackingStatus(ctx context.Context, x int, delay time.Duration) (TrackingStatus, bool) {
+	tag := "Migrator.waitForTrackingStatus(): [" + m.trackingKey(x).String() + "] "
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		ts, err := m.trackingStore.Get(m.trackingKey(x))
+		if err == nil {
+			m.observePosition(x, ts)
+			if attempt > 0 {
+				m.iterationStatus(x).setState(I_RUNNING)
+			}
+			return ts, true
+		}
+		logger.Warnf(tag+"GetTrackingStatus[Attempt %d, state=%s]: %s", attempt, m.State().String(), err.Error())
+		m.observeStage(x, StageTracking, start, err)
+		m.iterationStatus(x).setError(err)
+		m.iterationStatus(x).setState(I_BACKING_OFF)
+		if !sleepWithInterrupt(ctx, delay) {
+			return ts, false
+		}
+	}
 }
 
 // Close forcibly closes the database connections for the Migrator instance
-// and marks it as being uninitialized.
+// and marks it as being uninitialized. A running migrator closes itself
+// once it has stopped.
 func (m *Migrator) Close() {
 	tag := "Migrator.Close(): [" + m.SourceDsn.DBName + "] "
 
-	logger.Infof(tag + "Closing connections")
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	logger.Info(tag + "Closing connections")
+	closeBinlogStreams(m.SourceDsn.Addr, m.SourceDsn.DBName)
 	if m.sourceDb != nil {
-		logger.Infof(tag + "Closing source db connection")
+		logger.Info(tag + "Closing source db connection")
 		m.sourceDb.Close()
+		m.sourceDb = nil
 	}
 	if m.destinationDb != nil {
-		logger.Infof(tag + "Closing destination db connection")
+		logger.Info(tag + "Closing destination db connection")
 		m.destinationDb.Close()
+		m.destinationDb = nil
+	}
+	for x := range m.Iterations {
+		if m.Iterations[x].deadLetter != nil {
+			logger.Infof(tag+"Closing dead letter queue for %s", m.Iterations[x].SourceTable)
+			m.Iterations[x].deadLetter.Close()
+			m.Iterations[x].deadLetter = nil
+		}
 	}
 
 	m.initialized = false
 }
 
 // Quit is the method which should be used as the "preferred method" for
-// terminating a Migrator instance.
+// terminating a Migrator instance. Any running queries are interrupted.
 func (m *Migrator) Quit() error {
 	tag := "Migrator.Quit(): "
 
+	m.mutex.Lock()
 	if m.state == S_STOPPED {
-		log.Warnf(tag + "State == S_STOPPED")
+		m.mutex.Unlock()
+		logger.Warn(tag + "State == S_STOPPED")
 		return nil
 	}
 
 	if !m.initialized {
-		m.state = S_STOPPED
+		m.setState(S_STOPPED)
+		m.mutex.Unlock()
 		return errors.New(tag + "Not initialized")
 	}
 
-	logger.Infof(tag + "Setting state to S_STOPPING")
-	m.state = S_STOPPING
+	logger.Info(tag + "Setting state to S_STOPPING")
+	m.setState(S_STOPPING)
+	cancel := m.cancel
+	m.mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
 
 	return nil
 }
 
 // GetTrackingStatus retrieves the live tracking status for an Iteration from
-// the destination database tracking table
+// the migrator's TrackingStore
 func (m *Migrator) GetTrackingStatus(iter Iteration) (TrackingStatus, error) {
-	return GetTrackingStatus(m.destinationDb, m.SourceDsn.DBName, iter.SourceTable)
+	if m.trackingStore == nil {
+		return TrackingStatus{}, errors.New("Migrator.GetTrackingStatus(): Not initialized")
+	}
+	return m.trackingStore.Get(TrackingKey{
+		SourceDatabase:   m.SourceDsn.DBName,
+		SourceTable:      iter.SourceTable,
+		DestinationTable: iter.DestinationTable,
+		IterationID:      iter.ID,
+	})
+}
+
+// trackingKey returns the TrackingKey which identifies the TrackingStatus
+// for an Iteration.
+func (m *Migrator) trackingKey(x int) TrackingKey {
+	return TrackingKey{
+		SourceDatabase:   m.SourceDsn.DBName,
+		SourceTable:      m.Iterations[x].SourceTable,
+		DestinationTable: m.Iterations[x].DestinationTable,
+		IterationID:      m.Iterations[x].ID,
+	}
 }
 
 // SerializeTrackingStatus serializes a live tracking status for the current
-// migrator.
+// migrator to its TrackingStore.
 func (m *Migrator) SerializeTrackingStatus(ts TrackingStatus) error {
-	return SerializeTrackingStatus(m.destinationDb, ts)
+	return m.trackingStore.Update(ts)
 }
 
 // ParseDSN parses the given go-sql-driver/mysql datasource name.
@@ -0,0 +1,136 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// pipelineBatch is a transformed batch of rows waiting to be loaded by a
+// pipelined Iteration, along with the tracking position following it. The
+// Parameters are copied once the batch has been extracted, as Extractors
+// may modify them while the batch is being loaded. The BatchSize it was
+// extracted with and the time taken to extract and transform it are kept
+// for adaptive batch sizing.
+type pipelineBatch struct {
+	rows    int
+	data    []TableData
+	ts      TrackingStatus
+	params  *Parameters
+	more    bool
+	size    int
+	elapsed time.Duration
+}
+
+// runPipeline extracts and transforms batches of an Iteration in one
+// goroutine while loading them in another, connected by a channel holding
+// up to ParamPipelineDepth batches so that extraction blocks when loading
+// falls behind. Batches are loaded in the order they were extracted, so
+// tracking positions are committed in order. The pipeline runs until the
+// Extractor finds no more rows, a rewind is requested or a batch fails to
+// load, in which case batches extracted after it are discarded and
+// extraction restarts from the last committed position. As the discarded
+// batches must be extracted again, Init() refuses to pipeline Iterations
+// whose Extractor removes rows from its source. It returns the
+// last committed TrackingStatus, whether more rows may be waiting, and
+// false if the Iteration must stop.
+func (m *Migrator) runPipeline(ctx context.Context, x int, ts TrackingStatus) (TrackingStatus, bool, bool) {
+	tag := "Migrator.runPipeline(): [" + m.trackingKey(x).String() + "] "
+	depth := max(paramInt(*m.Iterations[x].Parameters, ParamPipelineDepth, 2), 1)
+	status := m.iterationStatus(x)
+
+	extractCtx, cancel := context.WithCancel(ctx)
+	batches := make(chan pipelineBatch, depth)
+	extracted := make(chan bool, 1)
+	go func() {
+		defer close(batches)
+		extracted <- m.pipelineExtract(extractCtx, x, ts, batches)
+	}()
+	// Wait for the extracting goroutine to finish, so that it does not
+	// outlive the Iteration
+	defer func() {
+		cancel()
+		for range batches {
+		}
+	}()
+
+	more := true
+	for batch := range batches {
+		tsLoad := time.Now()
+		loaded, ok := m.load(ctx, x, batch.data, batch.ts, batch.params)
+		if !ok {
+			return ts, false, false
+		}
+		if !loaded {
+			cancel()
+			// Retain the previous position so that the batch is retried
+			logger.Warnf(tag+"Retaining tracking position %s", ts.String())
+			if !m.backoff(ctx, x, StageLoader) {
+				return ts, true, false
+			}
+			return ts, true, true
+		}
+		m.observeBatchSize(x, batch.rows, batch.size, batch.elapsed+time.Since(tsLoad))
+		ts = batch.ts
+		more = batch.more
+		status.setBatch(batch.rows)
+		m.observePosition(x, ts)
+	}
+	if !<-extracted {
+		return ts, more, false
+	}
+	return ts, more, ctx.Err() == nil
+}
+
+// pipelineExtract extracts and transforms batches of an Iteration from a
+// tracking position, sending them to be loaded until the Extractor finds
+// no more rows or a rewind is requested. It returns false if the Iteration
+// must stop.
+func (m *Migrator) pipelineExtract(ctx context.Context, x int, ts TrackingStatus, batches chan<- pipelineBatch) (ok bool) {
+	tag := "Migrator.pipelineExtract(): [" + m.trackingKey(x).String() + "] "
+	status := m.iterationStatus(x)
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf(tag+"Panic: %v", r)
+			err := fmt.Errorf("%s: panic: %v", m.trackingKey(x).String(), r)
+			status.setError(err)
+			status.setState(I_ERRORED)
+			m.fail(err)
+			ok = false
+		}
+	}()
+
+	for {
+		if !m.waitWhilePaused(ctx, x) {
+			return false
+		}
+		if status.rewindPending() {
+			return true
+		}
+
+		size := m.applyBatchSize(x)
+		tsBatch := time.Now()
+		more, rows, newTs, ok := m.extract(ctx, x, ts)
+		if !ok {
+			return false
+		}
+		batch := pipelineBatch{
+			rows:   len(rows),
+			data:   m.transform(x, rows),
+			ts:     newTs,
+			params: cloneParams(m.Iterations[x].params),
+			more:   more,
+			size:   size,
+		}
+		batch.elapsed = time.Since(tsBatch)
+		select {
+		case batches <- batch:
+		case <-ctx.Done():
+			return false
+		}
+		if !more {
+			return true
+		}
+		ts = newTs
+	}
+}
@@ -59,7 +59,44 @@ func (pq *PersistenceQueue) GrabItem(item any, fn func(any) error) error {
 	return err
 }
 
+// PeekItem retrieves the item at the specified offset from the head of the
+// FIFO queue without removing it
+func (pq *PersistenceQueue) PeekItem(offset uint64, item any) error {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	x, err := pq.queue.PeekByOffset(offset)
+	if err != nil {
+		return err
+	}
+	return x.ToObject(item)
+}
+
+// Length returns the number of items in the FIFO queue
+func (pq *PersistenceQueue) Length() uint64 {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	return pq.queue.Length()
+}
+
+// Purge removes all items from the FIFO queue, returning the number of
+// items removed
+func (pq *PersistenceQueue) Purge() (int, error) {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	count := 0
+	for pq.queue.Length() > 0 {
+		_, err := pq.queue.Dequeue()
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
 // Close closes the underlying FIFO queue
 func (pq *PersistenceQueue) Close() {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
 	pq.queue.Close()
 }
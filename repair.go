@@ -0,0 +1,185 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RepairResult is the result of repairing the destination table of an
+// Iteration.
+type RepairResult struct {
+	// VerifyResult is the comparison of the tables before they were
+	// repaired
+	VerifyResult
+	// Replaced is the number of source rows reloaded into the destination
+	Replaced int
+	// Removed is the number of destination rows removed because they are
+	// no longer present in the source
+	Removed int
+}
+
+// Repair compares the source and destination tables of a single Iteration,
+// identified by ID or source table name, as Verify does, and then heals
+// each mismatched range of keys. The source rows in the range are
+// re-extracted and loaded as REPLACE, and destination rows in the range
+// which are missing from the source are loaded as REMOVE, through the
+// Transformer and Loader of the Iteration. The tracked position of the
+// Iteration is not changed. Ranges which are in flight are not repaired.
+// If the Migrator is running, the Iteration should be paused while it is
+// repaired, so that a batch extracted before the repair is not loaded over
+// it.
+func (m *Migrator) Repair(ctx context.Context, name string) (RepairResult, error) {
+	x, err := m.findIteration(name)
+	if err != nil {
+		return RepairResult{}, err
+	}
+	return m.repair(ctx, x)
+}
+
+// RepairAll repairs the destination tables of all Iterations, in the order
+// in which they are defined. See Repair.
+func (m *Migrator) RepairAll(ctx context.Context) ([]RepairResult, error) {
+	out := make([]RepairResult, 0, len(m.Iterations))
+	for x := range m.Iterations {
+		result, err := m.repair(ctx, x)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, result)
+	}
+	return out, nil
+}
+
+func (m *Migrator) repair(ctx context.Context, x int) (RepairResult, error) {
+	tag := "Migrator.Repair(): [" + m.trackingKey(x).String() + "] "
+
+	verified, err := m.verify(ctx, x)
+	out := RepairResult{VerifyResult: verified}
+	if err != nil {
+		return out, err
+	}
+	if verified.Matched() {
+		return out, nil
+	}
+
+	m.mutex.Lock()
+	sourceDb, destinationDb := m.sourceDb, m.destinationDb
+	m.mutex.Unlock()
+	if sourceDb == nil || destinationDb == nil {
+		return out, errors.New(tag + "Not initialized")
+	}
+
+	for _, r := range verified.Mismatches {
+		if ctx.Err() != nil {
+			return out, ctx.Err()
+		}
+
+		var lower, upper []any
+		if r.Lower != "" {
+			lower, err = DecodeKeyTuple(r.Lower)
+			if err != nil {
+				return out, err
+			}
+		}
+		if r.Upper != "" {
+			upper, err = DecodeKeyTuple(r.Upper)
+			if err != nil {
+				return out, err
+			}
+		}
+		c := verified.filter.keyRange(verified.KeyColumns, lower, upper)
+
+		tsStart := time.Now()
+		rows, _, err := selectRows(ctx, sourceDb, verified.SourceTable, verified.KeyColumns, c, 0, "REPLACE")
+		if err != nil {
+			return out, err
+		}
+		removed, err := repairMissingRows(ctx, destinationDb, verified, c, rows)
+		if err != nil {
+			return out, err
+		}
+		rows = append(rows, removed...)
+
+		data := m.Iterations[x].Transformer(m.DestinationDsn.DBName, m.Iterations[x].DestinationTable, rows, m.Iterations[x].TransformerParameters)
+
+		// An empty TrackingStatus prevents the Loader from moving the
+		// tracked position
+		err = m.Iterations[x].Loader(ctx, destinationDb, data, TrackingStatus{}, m.Iterations[x].Parameters)
+		if err != nil {
+			logger.Errorf(tag+"Loader: %s", err.Error())
+			return out, err
+		}
+
+		out.Replaced += len(rows) - len(removed)
+		out.Removed += len(removed)
+		logger.Infof(tag+"Repaired %s: replaced %d, removed %d rows in %s", r.String(), len(rows)-len(removed), len(removed), time.Since(tsStart).String())
+	}
+
+	return out, nil
+}
+
+// repairMissingRows finds the destination rows matching a condition whose
+// keys are not present in the specified source rows, to be loaded as
+// REMOVE.
+func repairMissingRows(ctx context.Context, db *sql.DB, verified VerifyResult, c keyCondition, source []SQLRow) ([]SQLRow, error) {
+	present := map[string]bool{}
+	for _, r := range source {
+		key := make([]any, len(verified.KeyColumns))
+		for i, k := range verified.KeyColumns {
+			key[i] = r.Data[k]
+		}
+		encoded, err := repairKey(key)
+		if err != nil {
+			return nil, err
+		}
+		present[encoded] = true
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT "+keysetOrderBy(verified.KeyColumns)+" FROM `"+verified.DestinationTable+"`"+c.clause(), c.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	data := make([]SQLRow, 0)
+	for rows.Next() {
+		values := make([]any, len(verified.KeyColumns))
+		scanArgs := make([]any, len(values))
+		for i := range values {
+			scanArgs[i] = &values[i]
+		}
+		err = rows.Scan(scanArgs...)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := repairKey(values)
+		if err != nil {
+			return nil, err
+		}
+		if present[encoded] {
+			continue
+		}
+		rowData := SQLRow{Method: "REMOVE", Data: make(SQLUntypedRow, len(values))}
+		for i, k := range verified.KeyColumns {
+			rowData.Data[k] = values[i]
+		}
+		data = append(data, rowData)
+	}
+	return data, rows.Err()
+}
+
+// repairKey encodes a key scanned from either table so that keys can be
+// compared regardless of whether the driver returned them as strings or
+// as raw bytes.
+func repairKey(key []any) (string, error) {
+	normalized := make([]any, len(key))
+	for i, v := range key {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		normalized[i] = v
+	}
+	return EncodeKeyTuple(normalized)
+}
@@ -0,0 +1,147 @@
+package migrator
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrCircuitOpen is wrapped by the error recorded for an Iteration which
+// has been stopped after failing ParamCircuitBreakerThreshold consecutive
+// times. The other Iterations of the Migrator continue to run.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// retryPolicy tracks the consecutive failures of each stage of an
+// Iteration, from which the delay before retrying the stage is derived.
+// The delay doubles with each consecutive failure, from the initial delay
+// up to the maximum delay, and is randomly reduced by up to half so that
+// Iterations which failed together do not retry together.
+type retryPolicy struct {
+	mutex     sync.Mutex
+	delay     time.Duration
+	maxDelay  time.Duration
+	threshold int
+	failures  map[string]int
+}
+
+func newRetryPolicy(params Parameters) *retryPolicy {
+	r := &retryPolicy{
+		delay:     time.Duration(max(paramInt(params, ParamRetryDelay, 1000), 1)) * time.Millisecond,
+		threshold: paramInt(params, ParamCircuitBreakerThreshold, 0),
+		failures:  map[string]int{},
+	}
+	r.maxDelay = max(time.Duration(paramInt(params, ParamRetryMaxDelay, 60000))*time.Millisecond, r.delay)
+	return r
+}
+
+// failure records a failure of a stage, returning the delay before it
+// should be retried, the number of consecutive failures and whether the
+// circuit breaker has opened.
+func (r *retryPolicy) failure(stage string) (time.Duration, int, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.failures[stage]++
+	failures := r.failures[stage]
+	delay := r.maxDelay
+	if failures <= 32 {
+		delay = min(r.delay<<(failures-1), r.maxDelay)
+		if delay <= 0 {
+			delay = r.maxDelay
+		}
+	}
+	delay -= time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	return delay, failures, r.threshold > 0 && failures >= r.threshold
+}
+
+// success clears the consecutive failures of a stage.
+func (r *retryPolicy) success(stage string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.failures, stage)
+}
+
+// retryableError determines whether an error is transient, so that the
+// same statement is likely to succeed if it is retried: a deadlock, a lock
+// wait timeout, too many connections, or the loss of the connection to the
+// server.
+func retryableError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1040, // ER_CON_COUNT_ERROR
+			1053, // ER_SERVER_SHUTDOWN
+			1205, // ER_LOCK_WAIT_TIMEOUT
+			1213, // ER_LOCK_DEADLOCK
+			2006, // CR_SERVER_GONE_ERROR
+			2013: // CR_SERVER_LOST
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// initRetry creates the retryPolicy of an Iteration.
+func (m *Migrator) initRetry(x int) {
+	m.Iterations[x].retry = newRetryPolicy(*m.Iterations[x].Parameters)
+}
+
+// retrySucceeded clears the consecutive failures of a stage of an
+// Iteration once it has succeeded.
+func (m *Migrator) retrySucceeded(x int, stage string) {
+	if m.Iterations[x].retry != nil {
+		m.Iterations[x].retry.success(stage)
+	}
+}
+
+// backoff records a failure of a stage of an Iteration and sleeps before
+// it is retried. If the stage has failed ParamCircuitBreakerThreshold
+// consecutive times the Iteration is moved to the errored state instead,
+// and the error is reported to the ErrorCallback wrapping ErrCircuitOpen
+// and the last error of the Iteration. It returns false if the Iteration
+// must stop.
+func (m *Migrator) backoff(ctx context.Context, x int, stage string) bool {
+	tag := "Migrator.backoff(): [" + m.trackingKey(x).String() + "] "
+	status := m.iterationStatus(x)
+
+	delay, failures, open := m.Iterations[x].retry.failure(stage)
+	if open {
+		status.mutex.Lock()
+		lastErr := status.lastError
+		status.mutex.Unlock()
+		err := fmt.Errorf("%w: %s failed %d consecutive times: %w", ErrCircuitOpen, stage, failures, lastErr)
+		logger.Errorf(tag+"Stopping iteration: %s", err.Error())
+		status.setError(err)
+		status.setState(I_ERRORED)
+		if m.ErrorCallback != nil {
+			m.ErrorCallback(map[string]string{
+				"Stage":            stage,
+				"SourceDb":         m.SourceDsn.DBName,
+				"SourceTable":      m.Iterations[x].SourceTable,
+				"DestinationDb":    m.DestinationDsn.DBName,
+				"DestinationTable": m.Iterations[x].DestinationTable,
+			}, err)
+		}
+		return false
+	}
+
+	logger.Warnf(tag+"%s failed %d consecutive times, retrying in %s", stage, failures, delay.String())
+	status.setState(I_BACKING_OFF)
+	if !status.sleep(ctx, delay) {
+		return false
+	}
+	status.setState(I_RUNNING)
+	return true
+}
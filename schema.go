@@ -0,0 +1,85 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// paramPrimaryKeys is the parameter holding the primaryKeyCache of a
+// Migrator, populated by Init(). It is shared between copies of the
+// Parameters of an Iteration.
+const paramPrimaryKeys = "primaryKeys"
+
+// primaryKeyCache holds the primary key columns of destination tables, so
+// that Loaders look each of them up once rather than within every
+// transaction.
+type primaryKeyCache struct {
+	mutex *sync.Mutex
+	keys  map[string][]string
+}
+
+func newPrimaryKeyCache() *primaryKeyCache {
+	return &primaryKeyCache{
+		mutex: &sync.Mutex{},
+		keys:  map[string][]string{},
+	}
+}
+
+// tableColumnNames retrieves the names of the columns of a table, in
+// order, from information_schema.
+func tableColumnNames(ctx context.Context, db *sql.DB, dbName, tableName string) ([]string, error) {
+	return queryStrings(ctx, db, "SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", dbName, tableName)
+}
+
+// primaryKeyColumns retrieves the names of the columns which make up the
+// primary key of a table, in order, from information_schema.
+func primaryKeyColumns(ctx context.Context, db *sql.DB, dbName, tableName string) ([]string, error) {
+	return queryStrings(ctx, db, "SELECT COLUMN_NAME FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND INDEX_NAME = 'PRIMARY' ORDER BY SEQ_IN_INDEX", dbName, tableName)
+}
+
+// currentPrimaryKeyColumns retrieves the names of the columns which make
+// up the primary key of a table in the current database of a connection
+// or transaction, in order, from information_schema.
+func currentPrimaryKeyColumns(ctx context.Context, db queryer, tableName string) ([]string, error) {
+	return queryStrings(ctx, db, "SELECT COLUMN_NAME FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = 'PRIMARY' ORDER BY SEQ_IN_INDEX", tableName)
+}
+
+func queryStrings(ctx context.Context, db queryer, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]string, 0)
+	for rows.Next() {
+		var s string
+		err = rows.Scan(&s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// destinationPrimaryKey retrieves the names of the columns which make up
+// the primary key of a destination table, from the primaryKeyCache in the
+// Parameters if there is one, or from information_schema otherwise.
+func destinationPrimaryKey(ctx context.Context, db queryer, tableName string, params *Parameters) ([]string, error) {
+	cache, ok := (*params)[paramPrimaryKeys].(*primaryKeyCache)
+	if !ok {
+		return currentPrimaryKeyColumns(ctx, db, tableName)
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if primaryKey, ok := cache.keys[tableName]; ok {
+		return primaryKey, nil
+	}
+	primaryKey, err := currentPrimaryKeyColumns(ctx, db, tableName)
+	if err != nil {
+		return nil, err
+	}
+	cache.keys[tableName] = primaryKey
+	return primaryKey, nil
+}
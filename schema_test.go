@@ -0,0 +1,68 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// countingQueryer is a queryer which counts and fails every query.
+type countingQueryer struct {
+	queries int
+}
+
+var errCountingQueryer = errors.New("query not expected")
+
+func (q *countingQueryer) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	q.queries++
+	return nil, errCountingQueryer
+}
+
+func (q *countingQueryer) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	panic("QueryRowContext not expected")
+}
+
+func TestDestinationPrimaryKey(t *testing.T) {
+	ctx := context.Background()
+
+	// Without a cache every lookup queries information_schema
+	q := &countingQueryer{}
+	for i := 0; i < 2; i++ {
+		if _, err := destinationPrimaryKey(ctx, q, "t", &Parameters{}); !errors.Is(err, errCountingQueryer) {
+			t.Fatalf("got %v, expected the query error", err)
+		}
+	}
+	if q.queries != 2 {
+		t.Errorf("uncached lookups: got %d queries, expected 2", q.queries)
+	}
+
+	// Failed lookups are not cached
+	q = &countingQueryer{}
+	cache := newPrimaryKeyCache()
+	params := &Parameters{paramPrimaryKeys: cache}
+	if _, err := destinationPrimaryKey(ctx, q, "t", params); !errors.Is(err, errCountingQueryer) {
+		t.Fatalf("got %v, expected the query error", err)
+	}
+	if _, ok := cache.keys["t"]; ok {
+		t.Error("failed lookup was cached")
+	}
+
+	// Cached keys, including the empty key of a table without one, are
+	// returned without querying, and shared by copies of the Parameters
+	cache.keys["t"] = []string{"a", "b"}
+	cache.keys["nokey"] = []string{}
+	for _, table := range []string{"t", "nokey"} {
+		got, err := destinationPrimaryKey(ctx, q, table, cloneParams(params))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, cache.keys[table]) {
+			t.Errorf("%s: got %v, expected %v", table, got, cache.keys[table])
+		}
+	}
+	if q.queries != 1 {
+		t.Errorf("cached lookups: got %d queries, expected 1", q.queries)
+	}
+}
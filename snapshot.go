@@ -0,0 +1,335 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PhaseSnapshot is the tracking Phase of an Iteration whose initial
+	// snapshot is being copied
+	PhaseSnapshot = "snapshot"
+	// PhaseIncremental is the tracking Phase of an Iteration which has
+	// completed its initial snapshot and is extracting changes
+	PhaseIncremental = "incremental"
+)
+
+// snapshotExtractors are the extractors which can hand off from a
+// snapshot, mapped to whether they have a high-water mark to record.
+var snapshotExtractors = map[string]bool{
+	"sequential":         true,
+	"keyset":             true,
+	"timestamp":          true,
+	"timestamp_fallback": true,
+	"timestamp_keyset":   true,
+	"queue":              false,
+}
+
+// initialPhase determines whether an Iteration must copy the existing
+// contents of its source table before extracting incrementally, either
+// because it has never extracted anything or because a previous copy did
+// not complete, returning the Phase in which it should do so. A snapshot
+// takes precedence over a backfill if both are enabled. An empty string
+// is returned if no copy is required.
+func (m *Migrator) initialPhase(x int, ts TrackingStatus) string {
+	params := *m.Iterations[x].Parameters
+	snapshot := paramBool(params, ParamSnapshot, false)
+	backfill := paramBool(params, ParamBackfill, false)
+	switch ts.Phase {
+	case PhaseSnapshot:
+		if snapshot {
+			return PhaseSnapshot
+		}
+	case PhaseBackfill:
+		if backfill {
+			return PhaseBackfill
+		}
+	case "":
+		if ts.SequentialPosition != 0 || ts.TimestampPosition.Valid || ts.KeyPosition != "" || ts.BinlogFile != "" {
+			return ""
+		}
+		if snapshot {
+			return PhaseSnapshot
+		}
+		if backfill {
+			return PhaseBackfill
+		}
+	}
+	return ""
+}
+
+// snapshotConnections is the number of additional source connections used
+// by the snapshot of an Iteration.
+func snapshotConnections(params *Parameters) int {
+	if params == nil || !paramBool(*params, ParamSnapshot, false) {
+		return 0
+	}
+	return max(paramInt(*params, ParamSnapshotThreads, 4), 1) + 1
+}
+
+// snapshot copies the whole source table of an Iteration in parallel
+// chunks of primary keys, all read from the same consistent snapshot, then
+// records the high-water mark of that snapshot as the tracking position of
+// the Iteration so that its Extractor continues from that point. The
+// snapshot is established by holding a read lock on the source table while
+// each connection starts a consistent snapshot transaction, which requires
+// the LOCK TABLES privilege, and is restarted from the beginning if it does
+// not complete.
+func (m *Migrator) snapshot(ctx context.Context, x int, ts TrackingStatus) (TrackingStatus, error) {
+	tag := "Migrator.snapshot(): [" + m.trackingKey(x).String() + "] "
+	params := *m.Iterations[x].Parameters
+	threads := max(paramInt(params, ParamSnapshotThreads, 4), 1)
+	chunkSize := max(paramInt(params, ParamSnapshotChunkSize, 10000), 1)
+	table := m.Iterations[x].SourceTable
+
+	hasPosition, ok := snapshotExtractors[m.Iterations[x].ExtractorName]
+	if !ok {
+		return ts, errors.New(tag + "Extractor '" + m.Iterations[x].ExtractorName + "' does not support snapshots")
+	}
+
+	keyCols, err := primaryKeyColumns(ctx, m.sourceDb, m.SourceDsn.DBName, table)
+	if err != nil {
+		return ts, err
+	}
+	if len(keyCols) == 0 {
+		return ts, errors.New(tag + "Source table " + table + " has no primary key")
+	}
+
+	ts.Phase = PhaseSnapshot
+	err = m.trackingStore.Update(ts)
+	if err != nil {
+		return ts, err
+	}
+
+	logger.Infof(tag+"Starting snapshot with %d threads", threads)
+	start := time.Now()
+
+	conns, err := m.snapshotTransactions(ctx, table, threads)
+	defer func() {
+		for _, conn := range conns {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+			conn.Close()
+		}
+	}()
+	if err != nil {
+		return ts, err
+	}
+
+	// The high-water mark and chunk boundaries are read from the same
+	// snapshot as the data
+	newTs := ts
+	if hasPosition {
+		newTs, err = snapshotHighWaterMark(ctx, conns[0], table, ts, m.Iterations[x].ExtractorName)
+		if err != nil {
+			return ts, err
+		}
+	}
+	chunks := make([][2][]any, 0)
+	var lower []any
+	for {
+		upper, err := chunkBoundary(ctx, conns[0], table, keyCols, keyCondition{}.keyRange(keyCols, lower, nil), chunkSize)
+		if err != nil {
+			return ts, err
+		}
+		chunks = append(chunks, [2][]any{lower, upper})
+		if upper == nil {
+			break
+		}
+		lower = upper
+	}
+	logger.Infof(tag+"Copying %d chunks of up to %d rows, high-water mark %s", len(chunks), chunkSize, newTs.String())
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var errMutex, transformMutex sync.Mutex
+	var firstErr error
+	work := make(chan [2][]any)
+	for t := range conns {
+		wg.Add(1)
+		go func(conn *sql.Conn) {
+			defer wg.Done()
+			for chunk := range work {
+				err := m.snapshotChunk(ctx, x, conn, keyCols, chunk[0], chunk[1], &transformMutex)
+				if err != nil {
+					errMutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMutex.Unlock()
+					cancel()
+				}
+			}
+		}(conns[t])
+	}
+dispatch:
+	for _, chunk := range chunks {
+		if !m.waitWhilePaused(ctx, x) {
+			break
+		}
+		select {
+		case work <- chunk:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(work)
+	wg.Wait()
+	if firstErr != nil {
+		return ts, firstErr
+	}
+	if ctx.Err() != nil {
+		return ts, ctx.Err()
+	}
+
+	newTs.Db = ts.Db
+	newTs.Store = ts.Store
+	newTs.Phase = PhaseIncremental
+	newTs.LastRun = NullTimeNow()
+	err = m.trackingStore.Update(newTs)
+	if err != nil {
+		return ts, err
+	}
+	logger.Infof(tag+"Completed snapshot of %d chunks in %s, continuing from %s", len(chunks), time.Since(start).String(), newTs.String())
+	return newTs, nil
+}
+
+// snapshotTransactions starts the specified number of consistent snapshot
+// transactions against the source database while writes to the source
+// table are blocked, so that all of them see the same contents.
+func (m *Migrator) snapshotTransactions(ctx context.Context, table string, count int) ([]*sql.Conn, error) {
+	conns := make([]*sql.Conn, 0, count)
+
+	lock, err := m.sourceDb.Conn(ctx)
+	if err != nil {
+		return conns, err
+	}
+	defer lock.Close()
+	_, err = lock.ExecContext(ctx, "LOCK TABLES `"+table+"` READ")
+	if err != nil {
+		return conns, err
+	}
+	defer lock.ExecContext(context.Background(), "UNLOCK TABLES")
+
+	for range count {
+		conn, err := m.sourceDb.Conn(ctx)
+		if err != nil {
+			return conns, err
+		}
+		conns = append(conns, conn)
+		_, err = conn.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY")
+		if err != nil {
+			return conns, err
+		}
+	}
+	return conns, nil
+}
+
+// snapshotHighWaterMark determines the position within the source table at
+// which the Extractor of an Iteration should continue after a snapshot.
+func snapshotHighWaterMark(ctx context.Context, db queryer, table string, ts TrackingStatus, extractor string) (TrackingStatus, error) {
+	switch extractor {
+	case "sequential":
+		var maxKey sql.NullInt64
+		err := db.QueryRowContext(ctx, "SELECT MAX(`"+ts.ColumnName+"`) FROM `"+table+"`").Scan(&maxKey)
+		ts.SequentialPosition = maxKey.Int64
+		return ts, err
+
+	case "timestamp", "timestamp_fallback":
+		expr := "`" + ts.ColumnName + "`"
+		if extractor == "timestamp_fallback" {
+			cols := keysetColumns(ts.ColumnName)
+			if len(cols) < 2 {
+				return ts, errors.New("timestamp_fallback requires two columns separated by a comma")
+			}
+			expr = "IFNULL(`" + cols[0] + "`,`" + cols[1] + "`)"
+		}
+		var maxStamp NullTime
+		err := db.QueryRowContext(ctx, "SELECT MAX("+expr+") FROM `"+table+"`").Scan(&maxStamp)
+		ts.TimestampPosition = maxStamp
+		return ts, err
+
+	case "keyset", "timestamp_keyset":
+		cols := keysetColumns(ts.ColumnName)
+		if extractor == "timestamp_keyset" && len(cols) < 2 {
+			return ts, errors.New("timestamp_keyset requires a timestamp column followed by key columns, separated by commas")
+		}
+		last, err := lastKey(ctx, db, table, cols)
+		if err != nil || last == nil {
+			return ts, err
+		}
+		if extractor == "timestamp_keyset" {
+			stamp, ok := last[0].(time.Time)
+			if !ok {
+				return ts, fmt.Errorf("column %s is not a timestamp", cols[0])
+			}
+			ts.TimestampPosition = NullTimeFromTime(stamp)
+			last = last[1:]
+		}
+		ts.KeyPosition, err = EncodeKeyTuple(last)
+		return ts, err
+	}
+	return ts, nil
+}
+
+// lastKey finds the highest key tuple in a table, or nil if it is empty.
+func lastKey(ctx context.Context, db queryer, table string, cols []string) ([]any, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+keysetOrderBy(cols)+" FROM `"+table+"` WHERE `"+cols[0]+"` IS NOT NULL ORDER BY `"+strings.Join(cols, "` DESC, `")+"` DESC LIMIT 1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]any, len(cols))
+	scanArgs := make([]any, len(cols))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	err = rows.Scan(scanArgs...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range values {
+		values[i] = keysetValue(values[i], colTypes[i])
+	}
+	return values, nil
+}
+
+// snapshotChunk copies a single range of keys from a snapshot transaction
+// through the Transformer and Loader of an Iteration. Transformers are not
+// required to be safe for concurrent use, so they are called one at a
+// time.
+func (m *Migrator) snapshotChunk(ctx context.Context, x int, db queryer, keyCols []string, lower, upper []any, transformMutex *sync.Mutex) error {
+	tsStart := time.Now()
+	rows, _, err := selectRows(ctx, db, m.Iterations[x].SourceTable, keyCols, keyCondition{}.keyRange(keyCols, lower, upper), 0, "REPLACE")
+	if err != nil {
+		m.observeStage(x, StageSnapshot, tsStart, err)
+		return err
+	}
+	m.observeExtracted(x, rows)
+
+	transformMutex.Lock()
+	data := m.Iterations[x].Transformer(m.DestinationDsn.DBName, m.Iterations[x].DestinationTable, rows, m.Iterations[x].TransformerParameters)
+	transformMutex.Unlock()
+
+	// An empty TrackingStatus prevents the Loader from moving the tracked
+	// position before the snapshot is complete
+	err = m.Iterations[x].Loader(ctx, m.destinationDb, data, TrackingStatus{}, m.Iterations[x].Parameters)
+	m.observeStage(x, StageSnapshot, tsStart, err)
+	if err != nil {
+		return err
+	}
+	m.observeLoaded(x, data)
+	return nil
+}
@@ -70,3 +70,88 @@ func MigratorStateFromString(s string) (MigratorState, error) {
 		return S_INVALID, fmt.Errorf("invalid state: '%s'", s)
 	}
 }
+
+const (
+	// I_NEW is the status of an iteration which has not yet been run
+	I_NEW = 0
+	// I_RUNNING is the status of an iteration which is extracting and
+	// loading data
+	I_RUNNING = 1
+	// I_PAUSED is the status of an iteration which has been paused,
+	// either individually or along with its migrator
+	I_PAUSED = 2
+	// I_BACKING_OFF is the status of an iteration which is waiting to
+	// retry after an error
+	I_BACKING_OFF = 3
+	// I_ERRORED is the status of an iteration which has stopped because
+	// of an error
+	I_ERRORED = 4
+	// I_STOPPED is the status of an iteration which has been stopped
+	I_STOPPED = 5
+	// I_SNAPSHOT is the status of an iteration which is copying its
+	// initial snapshot
+	I_SNAPSHOT = 6
+	// I_BACKFILL is the status of an iteration which is copying the
+	// existing contents of its source table in parallel ranges
+	I_BACKFILL = 7
+	// I_THROTTLED is the status of an iteration whose extraction is held
+	// back because its source database is overloaded
+	I_THROTTLED = 8
+	// I_INVALID represents an invalid state
+	I_INVALID = -1
+)
+
+// IterationState represents the state of a single Iteration within a
+// Migrator.
+type IterationState int
+
+func (s IterationState) String() string {
+	switch s {
+	case I_NEW:
+		return "I_NEW"
+	case I_RUNNING:
+		return "I_RUNNING"
+	case I_PAUSED:
+		return "I_PAUSED"
+	case I_BACKING_OFF:
+		return "I_BACKING_OFF"
+	case I_ERRORED:
+		return "I_ERRORED"
+	case I_STOPPED:
+		return "I_STOPPED"
+	case I_SNAPSHOT:
+		return "I_SNAPSHOT"
+	case I_BACKFILL:
+		return "I_BACKFILL"
+	case I_THROTTLED:
+		return "I_THROTTLED"
+	default:
+		return "I_INVALID"
+	}
+}
+
+// IterationStateFromString derives an iteration state from a string
+func IterationStateFromString(s string) (IterationState, error) {
+	switch s {
+	case "I_NEW":
+		return I_NEW, nil
+	case "I_RUNNING":
+		return I_RUNNING, nil
+	case "I_PAUSED":
+		return I_PAUSED, nil
+	case "I_BACKING_OFF":
+		return I_BACKING_OFF, nil
+	case "I_ERRORED":
+		return I_ERRORED, nil
+	case "I_STOPPED":
+		return I_STOPPED, nil
+	case "I_SNAPSHOT":
+		return I_SNAPSHOT, nil
+	case "I_BACKFILL":
+		return I_BACKFILL, nil
+	case "I_THROTTLED":
+		return I_THROTTLED, nil
+	default:
+		return I_INVALID, fmt.Errorf("invalid state: '%s'", s)
+	}
+}
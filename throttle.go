@@ -0,0 +1,206 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// newRateLimiter creates a limiter allowing the specified number of events
+// per second, with a burst of one second's worth, or nil if the limit is
+// not positive.
+func newRateLimiter(perSecond int) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), perSecond)
+}
+
+// waitRate waits until a limiter permits n events, in steps no larger than
+// its burst. A nil limiter permits everything.
+func waitRate(ctx context.Context, limiter *rate.Limiter, n int) error {
+	if limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		step := min(n, limiter.Burst())
+		err := limiter.WaitN(ctx, step)
+		if err != nil {
+			return err
+		}
+		n -= step
+	}
+	return nil
+}
+
+// initRateLimits creates the rate limiters of the Migrator, which are
+// shared by all of its Iterations, and of each Iteration.
+func (m *Migrator) initRateLimits() {
+	if m.Parameters != nil {
+		m.rowLimiter = newRateLimiter(paramInt(*m.Parameters, ParamRowsPerSecond, 0))
+		m.queryLimiter = newRateLimiter(paramInt(*m.Parameters, ParamQueriesPerSecond, 0))
+	}
+	m.health = &sourceHealth{}
+	for x := range m.Iterations {
+		m.Iterations[x].rowLimiter = newRateLimiter(paramInt(*m.Iterations[x].Parameters, ParamRowsPerSecond, 0))
+		m.Iterations[x].queryLimiter = newRateLimiter(paramInt(*m.Iterations[x].Parameters, ParamQueriesPerSecond, 0))
+		if paramInt(*m.Iterations[x].Parameters, ParamThrottleReplicaLag, 0) > 0 {
+			m.health.replica = true
+		}
+	}
+}
+
+// throttleExtract waits before an Iteration runs its Extractor, while the
+// source database is unhealthy and until the query rate limits of the
+// Iteration and the Migrator permit another extraction. It returns false
+// if the context is cancelled.
+func (m *Migrator) throttleExtract(ctx context.Context, x int) bool {
+	if !m.waitForSourceHealth(ctx, x) {
+		return false
+	}
+	if waitRate(ctx, m.Iterations[x].queryLimiter, 1) != nil {
+		return false
+	}
+	return waitRate(ctx, m.queryLimiter, 1) == nil
+}
+
+// throttleRows waits after an Iteration has extracted rows until the row
+// rate limits of the Iteration and the Migrator permit them. It returns
+// false if the context is cancelled.
+func (m *Migrator) throttleRows(ctx context.Context, x int, rows int) bool {
+	if waitRate(ctx, m.Iterations[x].rowLimiter, rows) != nil {
+		return false
+	}
+	return waitRate(ctx, m.rowLimiter, rows) == nil
+}
+
+// sourceHealth caches the most recent health check of a source database,
+// so that Iterations sharing it do not each query it. The replication lag
+// is only checked if replica is set, as it requires the REPLICATION CLIENT
+// privilege.
+type sourceHealth struct {
+	replica        bool
+	mutex          sync.Mutex
+	checked        time.Time
+	threadsRunning int64
+	replicaLag     sql.NullInt64
+	err            error
+}
+
+// check returns the number of running threads and the replication lag in
+// seconds of the source database, querying it if the cached values are
+// older than the specified interval, along with whether it was queried.
+// The replication lag is not valid unless the source is a replica. A
+// failed check is also cached.
+func (h *sourceHealth) check(ctx context.Context, db *sql.DB, interval time.Duration) (int64, sql.NullInt64, bool, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if time.Since(h.checked) < interval {
+		return h.threadsRunning, h.replicaLag, false, h.err
+	}
+
+	var name string
+	h.replicaLag = sql.NullInt64{}
+	h.err = db.QueryRowContext(ctx, "SHOW GLOBAL STATUS LIKE 'Threads_running'").Scan(&name, &h.threadsRunning)
+	if h.err == nil && h.replica {
+		h.replicaLag, h.err = replicaLag(ctx, db)
+	}
+	if ctx.Err() != nil {
+		return 0, sql.NullInt64{}, false, ctx.Err()
+	}
+	h.checked = time.Now()
+	return h.threadsRunning, h.replicaLag, true, h.err
+}
+
+// replicaLag reads the Seconds_Behind_Source ( or Seconds_Behind_Master,
+// before MySQL 8.0.22 ) of a replica, which is not valid if the database
+// is not a replica or replication is not running.
+func replicaLag(ctx context.Context, db *sql.DB) (sql.NullInt64, error) {
+	var lag sql.NullInt64
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS")
+		if err != nil {
+			return lag, err
+		}
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return lag, err
+	}
+	if !rows.Next() {
+		return lag, rows.Err()
+	}
+	values := make([]sql.RawBytes, len(cols))
+	scanArgs := make([]any, len(cols))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	err = rows.Scan(scanArgs...)
+	if err != nil {
+		return lag, err
+	}
+	for i, col := range cols {
+		if (col == "Seconds_Behind_Source" || col == "Seconds_Behind_Master") && values[i] != nil {
+			lag.Int64, err = strconv.ParseInt(string(values[i]), 10, 64)
+			lag.Valid = err == nil
+		}
+	}
+	return lag, nil
+}
+
+// waitForSourceHealth blocks while the source database of an Iteration
+// exceeds its ParamThrottleThreadsRunning or ParamThrottleReplicaLag,
+// rechecking every ParamThrottleCheckInterval seconds. Errors checking the
+// source are logged and do not hold back extraction. It returns false if
+// the context is cancelled.
+func (m *Migrator) waitForSourceHealth(ctx context.Context, x int) bool {
+	tag := "Migrator.waitForSourceHealth(): [" + m.trackingKey(x).String() + "] "
+	params := *m.Iterations[x].Parameters
+	maxThreads := paramInt(params, ParamThrottleThreadsRunning, 0)
+	maxLag := paramInt(params, ParamThrottleReplicaLag, 0)
+	if maxThreads <= 0 && maxLag <= 0 {
+		return true
+	}
+	interval := time.Duration(max(paramInt(params, ParamThrottleCheckInterval, 1), 1)) * time.Second
+	status := m.iterationStatus(x)
+
+	throttled := false
+	for {
+		threads, lag, fresh, err := m.health.check(ctx, m.sourceDb, interval)
+		if ctx.Err() != nil {
+			return false
+		}
+		if err != nil && fresh {
+			logger.Warnf(tag+"Unable to check source health: %s", err.Error())
+		}
+		switch {
+		case err == nil && maxThreads > 0 && threads > int64(maxThreads):
+			if !throttled {
+				logger.Warnf(tag+"Throttling extraction, %d threads running on source", threads)
+			}
+		case err == nil && maxLag > 0 && lag.Valid && lag.Int64 > int64(maxLag):
+			if !throttled {
+				logger.Warnf(tag+"Throttling extraction, source is %d seconds behind its primary", lag.Int64)
+			}
+		default:
+			if throttled {
+				logger.Info(tag + "Resuming extraction")
+				status.setState(I_RUNNING)
+			}
+			return true
+		}
+		if !throttled {
+			throttled = true
+			status.setState(I_THROTTLED)
+		}
+		if !sleepWithInterrupt(ctx, interval) {
+			return false
+		}
+	}
+}
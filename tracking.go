@@ -4,27 +4,119 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
 // TrackingStatus is the table definition for the tracking table which
 // maintains the ETL positioning
 type TrackingStatus struct {
-	Db                 *sql.DB  `json:"-"`
-	SourceDatabase     string   `json:"source-database" db:"sourceDatabase"`
-	SourceTable        string   `json:"source-table" db:"sourceTable"`
-	ColumnName         string   `json:"column-name" db:"columnName"`
-	SequentialPosition int64    `json:"sequential-position" db:"sequentialPosition"`
-	TimestampPosition  NullTime `json:"timestamp-position" db:"timestampPosition"`
-	LastRun            NullTime `json:"last-run" db:"lastRun"`
+	Db                 *sql.DB       `json:"-"`
+	Store              TrackingStore `json:"-"`
+	SourceDatabase     string        `json:"source-database" db:"sourceDatabase"`
+	SourceTable        string        `json:"source-table" db:"sourceTable"`
+	DestinationTable   string        `json:"destination-table" db:"destinationTable"`
+	IterationID        string        `json:"iteration-id" db:"iterationId"`
+	ColumnName         string        `json:"column-name" db:"columnName"`
+	SequentialPosition int64         `json:"sequential-position" db:"sequentialPosition"`
+	TimestampPosition  NullTime      `json:"timestamp-position" db:"timestampPosition"`
+	BinlogFile         string        `json:"binlog-file" db:"binlogFile"`
+	BinlogPosition     int64         `json:"binlog-position" db:"binlogPosition"`
+	GtidSet            string        `json:"gtid-set" db:"gtidSet"`
+	KeyPosition        string        `json:"key-position" db:"keyPosition"`
+	Phase              string        `json:"phase" db:"phase"`
+	PhaseState         string        `json:"phase-state" db:"phaseState"`
+	LastRun            NullTime      `json:"last-run" db:"lastRun"`
+}
+
+// trackingColumns is the ordered list of columns read from and written to
+// the tracking table.
+var trackingColumns = "sourceDatabase, sourceTable, destinationTable, iterationId, columnName, sequentialPosition, timestampPosition, binlogFile, binlogPosition, gtidSet, keyPosition, phase, phaseState, lastRun"
+
+// trackingTableUpgrades maps columns which have been added to the tracking
+// table after its initial definition to the DDL required to add them to an
+// existing table.
+var trackingTableUpgrades = []struct {
+	Column     string
+	Definition string
+}{
+	{"binlogFile", "binlogFile VARCHAR(255) DEFAULT '' AFTER timestampPosition"},
+	{"binlogPosition", "binlogPosition BIGINT DEFAULT 0 AFTER binlogFile"},
+	{"gtidSet", "gtidSet TEXT AFTER binlogPosition"},
+	{"keyPosition", "keyPosition TEXT AFTER gtidSet"},
+	{"destinationTable", "destinationTable VARCHAR(100) NOT NULL DEFAULT '' AFTER sourceTable"},
+	{"iterationId", "iterationId VARCHAR(100) NOT NULL DEFAULT '' AFTER destinationTable"},
+	{"phase", "phase VARCHAR(20) NOT NULL DEFAULT '' AFTER keyPosition"},
+	{"phaseState", "phaseState MEDIUMTEXT AFTER phase"},
+}
+
+// trackingPrimaryKey is the ordered list of columns which identify a row in
+// the tracking table.
+var trackingPrimaryKey = []string{"sourceDatabase", "sourceTable", "destinationTable", "iterationId"}
+
+// trackingKeyWhere is the WHERE clause ( without the keyword ) which
+// selects a single row of the tracking table, to be bound with
+// TrackingKey.args().
+var trackingKeyWhere = "sourceDatabase = ? AND sourceTable = ? AND destinationTable = ? AND iterationId = ?"
+
+// TrackingKey identifies the TrackingStatus for an Iteration. Iterations
+// which read the same source table into different destination tables, or
+// which share a destination table but are distinguished by an ID, are
+// tracked independently.
+type TrackingKey struct {
+	SourceDatabase   string
+	SourceTable      string
+	DestinationTable string
+	IterationID      string
+}
+
+// String produces a representation of a TrackingKey which is suitable for
+// use as a key or file name. Keys without a destination table or iteration
+// ID are represented as "database.table", as they were before those fields
+// were introduced.
+func (k TrackingKey) String() string {
+	out := k.SourceDatabase + "." + k.SourceTable
+	if k.DestinationTable != "" || k.IterationID != "" {
+		out += "." + k.DestinationTable
+	}
+	if k.IterationID != "" {
+		out += "." + k.IterationID
+	}
+	return out
+}
+
+// Legacy returns the TrackingKey which was used for the same source table
+// before tracking included the destination table and iteration ID.
+func (k TrackingKey) Legacy() TrackingKey {
+	return TrackingKey{SourceDatabase: k.SourceDatabase, SourceTable: k.SourceTable}
+}
+
+func (k TrackingKey) args() []any {
+	return []any{k.SourceDatabase, k.SourceTable, k.DestinationTable, k.IterationID}
+}
+
+// Key returns the TrackingKey which identifies a TrackingStatus.
+func (t TrackingStatus) Key() TrackingKey {
+	return TrackingKey{
+		SourceDatabase:   t.SourceDatabase,
+		SourceTable:      t.SourceTable,
+		DestinationTable: t.DestinationTable,
+		IterationID:      t.IterationID,
+	}
 }
 
 // String produces a human readable representation of a TrackingStatus object.
 func (t TrackingStatus) String() string {
-	out := "TrackingStatus[" + t.SourceDatabase + "." + t.SourceTable + "]: "
+	out := "TrackingStatus[" + t.Key().String() + "]: "
 	if t.TimestampPosition.Valid {
 		return out + t.TimestampPosition.Time.String()
 	}
+	if t.BinlogFile != "" {
+		return out + fmt.Sprintf("%s:%d", t.BinlogFile, t.BinlogPosition)
+	}
+	if t.KeyPosition != "" {
+		return out + t.KeyPosition
+	}
 	return out + fmt.Sprintf("%d", t.SequentialPosition)
 }
 
@@ -35,12 +127,93 @@ func CreateTrackingTable(db *sql.DB) error {
 	CREATE TABLE IF NOT EXISTS ` + TrackingTableName + ` (
 		sourceDatabase		VARCHAR(100) DEFAULT '',
 		sourceTable		VARCHAR(100) DEFAULT '',
+		destinationTable	VARCHAR(100) NOT NULL DEFAULT '',
+		iterationId		VARCHAR(100) NOT NULL DEFAULT '',
 		columnName		VARCHAR(100) DEFAULT '',
 		sequentialPosition	BIGINT DEFAULT 0,
-		timestampPosition	TIMESTAMP NULL DEFAULT NULL,
+		timestampPosition	TIMESTAMP(6) NULL DEFAULT NULL,
+		binlogFile		VARCHAR(255) DEFAULT '',
+		binlogPosition		BIGINT DEFAULT 0,
+		gtidSet			TEXT,
+		keyPosition		TEXT,
+		phase			VARCHAR(20) NOT NULL DEFAULT '',
+		phaseState		MEDIUMTEXT,
 		lastRun			TIMESTAMP NULL DEFAULT NULL,
-		PRIMARY KEY ( sourceDatabase, sourceTable )
+		PRIMARY KEY ( sourceDatabase, sourceTable, destinationTable, iterationId )
 	);`)
+	if err != nil {
+		return err
+	}
+	return upgradeTrackingTable(db)
+}
+
+// upgradeTrackingTable adds any columns missing from a tracking table
+// which was created by an older version of the migrator, adds fractional
+// seconds to its timestamp position, and extends its primary key to
+// include the destination table and iteration ID. Existing rows are left
+// with an empty destination table and iteration ID, and are claimed by the
+// first Iteration which reads from their source table.
+func upgradeTrackingTable(db *sql.DB) error {
+	for _, u := range trackingTableUpgrades {
+		var count int
+		err := db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?", TrackingTableName, u.Column).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		logger.Infof("upgradeTrackingTable(): Adding column %s to %s", u.Column, TrackingTableName)
+		_, err = db.Exec("ALTER TABLE `" + TrackingTableName + "` ADD COLUMN " + u.Definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A timestamp position without fractional seconds is rounded when it
+	// is read back, which skips rows following a ( timestamp, key )
+	// position within the same second
+	var precision sql.NullInt64
+	err := db.QueryRow("SELECT DATETIME_PRECISION FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = 'timestampPosition'", TrackingTableName).Scan(&precision)
+	if err != nil {
+		return err
+	}
+	if precision.Int64 < 6 {
+		logger.Infof("upgradeTrackingTable(): Adding fractional seconds to timestampPosition in %s", TrackingTableName)
+		_, err = db.Exec("ALTER TABLE `" + TrackingTableName + "` MODIFY COLUMN timestampPosition TIMESTAMP(6) NULL DEFAULT NULL")
+		if err != nil {
+			return err
+		}
+	}
+
+	rows, err := db.Query("SELECT COLUMN_NAME FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = 'PRIMARY' ORDER BY SEQ_IN_INDEX", TrackingTableName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	pk := make([]string, 0)
+	for rows.Next() {
+		var col string
+		err = rows.Scan(&col)
+		if err != nil {
+			return err
+		}
+		pk = append(pk, col)
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	if strings.Join(pk, ",") == strings.Join(trackingPrimaryKey, ",") {
+		return nil
+	}
+
+	logger.Infof("upgradeTrackingTable(): Changing primary key of %s from ( %s ) to ( %s )", TrackingTableName, strings.Join(pk, ", "), strings.Join(trackingPrimaryKey, ", "))
+	alter := "ALTER TABLE `" + TrackingTableName + "` "
+	if len(pk) > 0 {
+		alter += "DROP PRIMARY KEY, "
+	}
+	_, err = db.Exec(alter + "ADD PRIMARY KEY ( " + strings.Join(trackingPrimaryKey, ", ") + " )")
 	return err
 }
 
@@ -50,24 +223,45 @@ func SerializeNewTrackingStatus(tt TrackingStatus) error {
 	if tt.SourceDatabase == "" || tt.SourceTable == "" || tt.ColumnName == "" {
 		return errors.New("SerializeNewTrackingStatus(): Unable to write incomplete record to database")
 	}
-	_, err := tt.Db.Exec("INSERT INTO `"+TrackingTableName+"` ( sourceDatabase, sourceTable, columnName, sequentialPosition, timestampPosition, lastRun ) VALUES ( ?, ?, ?, ?, ?, ? )", tt.SourceDatabase, tt.SourceTable, tt.ColumnName, tt.SequentialPosition, tt.TimestampPosition, tt.LastRun)
+	_, err := tt.Db.Exec("INSERT INTO `"+TrackingTableName+"` ( "+trackingColumns+" ) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? )", tt.SourceDatabase, tt.SourceTable, tt.DestinationTable, tt.IterationID, tt.ColumnName, tt.SequentialPosition, tt.TimestampPosition, tt.BinlogFile, tt.BinlogPosition, tt.GtidSet, tt.KeyPosition, tt.Phase, tt.PhaseState, tt.LastRun)
 	return err
 }
 
 // GetTrackingStatus retrieves a TrackingStatus object from its underlying
 // database table.
-func GetTrackingStatus(db *sql.DB, sourceDatabase, sourceTable string) (TrackingStatus, error) {
+func GetTrackingStatus(db *sql.DB, key TrackingKey) (TrackingStatus, error) {
 	var out TrackingStatus
-	err := db.QueryRow("SELECT * FROM `"+TrackingTableName+"` WHERE sourceDatabase = ? AND sourceTable = ? LIMIT 1", sourceDatabase, sourceTable).Scan(&out.SourceDatabase, &out.SourceTable, &out.ColumnName, &out.SequentialPosition, &out.TimestampPosition, &out.LastRun)
+	var gtidSet, keyPosition, phaseState sql.NullString
+	err := db.QueryRow("SELECT "+trackingColumns+" FROM `"+TrackingTableName+"` WHERE "+trackingKeyWhere+" LIMIT 1", key.args()...).Scan(&out.SourceDatabase, &out.SourceTable, &out.DestinationTable, &out.IterationID, &out.ColumnName, &out.SequentialPosition, &out.TimestampPosition, &out.BinlogFile, &out.BinlogPosition, &gtidSet, &keyPosition, &out.Phase, &phaseState, &out.LastRun)
+	out.GtidSet = gtidSet.String
+	out.KeyPosition = keyPosition.String
+	out.PhaseState = phaseState.String
 	out.Db = db
 	return out, err
 }
 
+// ClaimLegacyTrackingStatus assigns a tracking table row which predates
+// the destination table and iteration ID ( and therefore has neither ) to
+// the specified TrackingKey, so that an existing position is carried over
+// to the first Iteration which reads from its source table. It returns
+// false if there was no such row to claim.
+func ClaimLegacyTrackingStatus(db *sql.DB, key TrackingKey) (bool, error) {
+	if key == key.Legacy() {
+		return false, nil
+	}
+	res, err := db.Exec("UPDATE `"+TrackingTableName+"` SET destinationTable = ?, iterationId = ? WHERE "+trackingKeyWhere, append([]any{key.DestinationTable, key.IterationID}, key.Legacy().args()...)...)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
+
 // GetTrackingStatusSequential retrieves the sequentialPosition for a
 // TrackingStatus from its underlying database table.
-func GetTrackingStatusSequential(db *sql.DB, sourceDatabase, sourceTable string) (int64, error) {
+func GetTrackingStatusSequential(db *sql.DB, key TrackingKey) (int64, error) {
 	var seq int64
-	err := db.QueryRow("SELECT sequentialPosition FROM `"+TrackingTableName+"` WHERE sourceDatabase = ? AND sourceTable = ? LIMIT 1", sourceDatabase, sourceTable).Scan(&seq)
+	err := db.QueryRow("SELECT sequentialPosition FROM `"+TrackingTableName+"` WHERE "+trackingKeyWhere+" LIMIT 1", key.args()...).Scan(&seq)
 	if err == nil && seq == 0 {
 		return 0, errors.New("GetTrackingSequenceSequential(): unable to get sequential sequence")
 	}
@@ -76,9 +270,9 @@ func GetTrackingStatusSequential(db *sql.DB, sourceDatabase, sourceTable string)
 
 // GetTrackingStatusTimestamp retrieves the timestampPosition for a
 // TrackingStatus from its underlying database table.
-func GetTrackingStatusTimestamp(db *sql.DB, sourceDatabase, sourceTable string) (NullTime, error) {
+func GetTrackingStatusTimestamp(db *sql.DB, key TrackingKey) (NullTime, error) {
 	var seq NullTime
-	err := db.QueryRow("SELECT timestampPosition FROM `"+TrackingTableName+"` WHERE sourceDatabase = ? AND sourceTable = ? LIMIT 1", sourceDatabase, sourceTable).Scan(&seq)
+	err := db.QueryRow("SELECT timestampPosition FROM `"+TrackingTableName+"` WHERE "+trackingKeyWhere+" LIMIT 1", key.args()...).Scan(&seq)
 	if err == nil && !seq.Valid {
 		return seq, errors.New("GetTrackingSequenceTimestamp(): unable to get timestamp sequence")
 	}
@@ -90,20 +284,38 @@ func GetTrackingStatusTimestamp(db *sql.DB, sourceDatabase, sourceTable string)
 // TrackingStatus to its underlying database table.
 func SerializeTrackingStatus(db *sql.DB, ts TrackingStatus) error {
 	logger.Debugf("SerializeTrackingStatus(): %s", ts)
-	_, err := db.Exec("UPDATE `"+TrackingTableName+"` SET sequentialPosition = ?, timestampPosition = ?, lastRun = ? WHERE sourceDatabase = ? AND sourceTable = ?", ts.SequentialPosition, ts.TimestampPosition, ts.LastRun, ts.SourceDatabase, ts.SourceTable)
+	_, err := db.Exec(serializeTrackingStatusQuery(), serializeTrackingStatusArgs(ts)...)
 	return err
 }
 
+// SerializeTrackingStatusTx serializes a copy of an actively modified
+// TrackingStatus to its underlying database table as part of an existing
+// sql.Tx (transaction), so that the position is only committed along with
+// the data it represents.
+func SerializeTrackingStatusTx(tx *sql.Tx, ts TrackingStatus) error {
+	logger.Debugf("SerializeTrackingStatusTx(): %s", ts)
+	_, err := tx.Exec(serializeTrackingStatusQuery(), serializeTrackingStatusArgs(ts)...)
+	return err
+}
+
+func serializeTrackingStatusQuery() string {
+	return "UPDATE `" + TrackingTableName + "` SET sequentialPosition = ?, timestampPosition = ?, binlogFile = ?, binlogPosition = ?, gtidSet = ?, keyPosition = ?, phase = ?, phaseState = ?, lastRun = ? WHERE " + trackingKeyWhere
+}
+
+func serializeTrackingStatusArgs(ts TrackingStatus) []any {
+	return append([]any{ts.SequentialPosition, ts.TimestampPosition, ts.BinlogFile, ts.BinlogPosition, ts.GtidSet, ts.KeyPosition, ts.Phase, ts.PhaseState, ts.LastRun}, ts.Key().args()...)
+}
+
 // SetTrackingStatusSequential updates a TrackingStatus object's
 // sequentialPosition in its underlying database table.
-func SetTrackingStatusSequential(db *sql.DB, sourceDatabase, sourceTable string, seq int64) error {
-	_, err := db.Exec("UPDATE `"+TrackingTableName+"` SET sequentialPosition = ?, lastRun = ? WHERE sourceDatabase = ? AND sourceTable = ?", seq, time.Now(), sourceDatabase, sourceTable)
+func SetTrackingStatusSequential(db *sql.DB, key TrackingKey, seq int64) error {
+	_, err := db.Exec("UPDATE `"+TrackingTableName+"` SET sequentialPosition = ?, lastRun = ? WHERE "+trackingKeyWhere, append([]any{seq, time.Now()}, key.args()...)...)
 	return err
 }
 
 // SetTrackingStatusTimestamp updates a TrackingStatus object's
 // timestampPosition in its underlying database table.
-func SetTrackingStatusTimestamp(db *sql.DB, sourceDatabase, sourceTable string, stamp time.Time) error {
-	_, err := db.Exec("UPDATE `"+TrackingTableName+"` SET timestampPosition = ?, lastRun = ? WHERE sourceDatabase = ? AND sourceTable = ?", stamp, time.Now(), sourceDatabase, sourceTable)
+func SetTrackingStatusTimestamp(db *sql.DB, key TrackingKey, stamp time.Time) error {
+	_, err := db.Exec("UPDATE `"+TrackingTableName+"` SET timestampPosition = ?, lastRun = ? WHERE "+trackingKeyWhere, append([]any{stamp, time.Now()}, key.args()...)...)
 	return err
 }
@@ -2,8 +2,17 @@
 CREATE TABLE `EtlPosition` (
 	sourceDatabase		VARCHAR(100) DEFAULT '',
 	sourceTable		VARCHAR(100) DEFAULT '',
+	destinationTable	VARCHAR(100) NOT NULL DEFAULT '',
+	iterationId		VARCHAR(100) NOT NULL DEFAULT '',
 	columnName		VARCHAR(100) DEFAULT '',
 	sequentialPosition	BIGINT DEFAULT 0,
-	timestampPosition	TIMESTAMP NULL DEFAULT NULL,
-	lastRun			TIMESTAMP NULL DEFAULT NULL
+	timestampPosition	TIMESTAMP(6) NULL DEFAULT NULL,
+	binlogFile		VARCHAR(255) DEFAULT '',
+	binlogPosition		BIGINT DEFAULT 0,
+	gtidSet			TEXT,
+	keyPosition		TEXT,
+	phase			VARCHAR(20) NOT NULL DEFAULT '',
+	phaseState		MEDIUMTEXT,
+	lastRun			TIMESTAMP NULL DEFAULT NULL,
+	PRIMARY KEY ( sourceDatabase, sourceTable, destinationTable, iterationId )
 );
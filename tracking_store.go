@@ -0,0 +1,66 @@
+package migrator
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var (
+	// ErrTrackingStatusNotFound is returned by a TrackingStore when no
+	// TrackingStatus exists for the requested TrackingKey.
+	ErrTrackingStatusNotFound = errors.New("tracking status not found")
+)
+
+// TrackingStore represents a backend which persists TrackingStatus objects
+// for a Migrator.
+type TrackingStore interface {
+	// Init prepares the store for use, creating any underlying storage
+	// which does not already exist.
+	Init() error
+
+	// Get retrieves the TrackingStatus identified by a TrackingKey,
+	// returning ErrTrackingStatusNotFound if none exists.
+	Get(key TrackingKey) (TrackingStatus, error)
+
+	// Create persists a new TrackingStatus.
+	Create(ts TrackingStatus) error
+
+	// Update persists changes to an existing TrackingStatus.
+	Update(ts TrackingStatus) error
+
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// TransactionalTrackingStore is a TrackingStore which may reside in the
+// destination database, and can then persist a TrackingStatus within the
+// same transaction as the data being loaded.
+type TransactionalTrackingStore interface {
+	TrackingStore
+
+	// InDatabase returns true if the store resides in the specified
+	// database, so that UpdateTx can be used with its transactions.
+	InDatabase(db *sql.DB) bool
+
+	// UpdateTx persists changes to an existing TrackingStatus as part of
+	// an existing sql.Tx (transaction).
+	UpdateTx(tx *sql.Tx, ts TrackingStatus) error
+}
+
+// serializeTrackingStatusTx persists a TrackingStatus as part of a loader
+// transaction against db if its TrackingStore resides in that database.
+// The returned function must be called once the transaction has been
+// committed, to persist the TrackingStatus to stores which cannot take
+// part in the transaction.
+func serializeTrackingStatusTx(db *sql.DB, tx *sql.Tx, ts TrackingStatus) (func() error, error) {
+	noop := func() error { return nil }
+	switch store := ts.Store.(type) {
+	case nil:
+		return noop, SerializeTrackingStatusTx(tx, ts)
+	case TransactionalTrackingStore:
+		if store.InDatabase(db) {
+			return noop, store.UpdateTx(tx, ts)
+		}
+	}
+	return func() error { return ts.Store.Update(ts) }, nil
+}
@@ -0,0 +1,117 @@
+package migrator
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileTrackingStore is a TrackingStore which persists TrackingStatus
+// objects as JSON in a local file. The whole file is rewritten on every
+// update, so this is best suited to a modest number of iterations. A single
+// FileTrackingStore should be shared by all migrators using the same file.
+type FileTrackingStore struct {
+	Path string
+
+	mutex    *sync.Mutex
+	statuses map[string]TrackingStatus
+}
+
+// NewFileTrackingStore creates a FileTrackingStore backed by the specified
+// JSON file.
+func NewFileTrackingStore(path string) *FileTrackingStore {
+	return &FileTrackingStore{
+		Path:     path,
+		mutex:    &sync.Mutex{},
+		statuses: map[string]TrackingStatus{},
+	}
+}
+
+// Init loads any existing tracking data from the file.
+func (s *FileTrackingStore) Init() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !FileExists(s.Path) {
+		return nil
+	}
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return err
+	}
+	statuses := map[string]TrackingStatus{}
+	err = json.Unmarshal(data, &statuses)
+	if err != nil {
+		return err
+	}
+	s.statuses = statuses
+	return nil
+}
+
+// Get retrieves a TrackingStatus from the file. If none exists, an entry
+// written before tracking included the destination table and iteration ID
+// is claimed for the TrackingKey, if present.
+func (s *FileTrackingStore) Get(key TrackingKey) (TrackingStatus, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ts, ok := s.statuses[key.String()]
+	if !ok {
+		ts, ok = s.statuses[key.Legacy().String()]
+		if !ok || key == key.Legacy() || ts.DestinationTable != "" || ts.IterationID != "" {
+			return TrackingStatus{}, ErrTrackingStatusNotFound
+		}
+		delete(s.statuses, key.Legacy().String())
+		ts.DestinationTable = key.DestinationTable
+		ts.IterationID = key.IterationID
+		s.statuses[key.String()] = ts
+		err := s.write()
+		if err != nil {
+			return TrackingStatus{}, err
+		}
+	}
+	ts.Store = s
+	return ts, nil
+}
+
+// Create writes a new TrackingStatus to the file.
+func (s *FileTrackingStore) Create(ts TrackingStatus) error {
+	return s.Update(ts)
+}
+
+// Update writes a TrackingStatus to the file.
+func (s *FileTrackingStore) Update(ts TrackingStatus) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.statuses[ts.Key().String()] = ts
+	return s.write()
+}
+
+// write atomically replaces the file with the current tracking data.
+func (s *FileTrackingStore) write() error {
+	data, err := json.MarshalIndent(s.statuses, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// Close does nothing for a FileTrackingStore, as all updates are written
+// immediately.
+func (s *FileTrackingStore) Close() error {
+	return nil
+}
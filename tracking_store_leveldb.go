@@ -0,0 +1,120 @@
+package migrator
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// LevelDBTrackingStore is a TrackingStore which persists TrackingStatus
+// objects as JSON in a local LevelDB database. The database can only be
+// opened once, so a single LevelDBTrackingStore should be shared by all
+// migrators using the same path.
+type LevelDBTrackingStore struct {
+	Path string
+
+	db *leveldb.DB
+}
+
+// NewLevelDBTrackingStore creates a LevelDBTrackingStore backed by a
+// LevelDB database in the specified directory.
+func NewLevelDBTrackingStore(path string) *LevelDBTrackingStore {
+	return &LevelDBTrackingStore{Path: path}
+}
+
+// Init opens the LevelDB database, creating it if it does not exist.
+func (s *LevelDBTrackingStore) Init() error {
+	if s.db != nil {
+		return nil
+	}
+	if !FileExists(s.Path) {
+		err := os.MkdirAll(s.Path, 0700)
+		if err != nil {
+			return err
+		}
+	}
+	db, err := leveldb.OpenFile(s.Path, nil)
+	if err != nil {
+		return err
+	}
+	s.db = db
+	return nil
+}
+
+// Get retrieves a TrackingStatus from the LevelDB database. If none
+// exists, an entry written before tracking included the destination table
+// and iteration ID is claimed for the TrackingKey, if present.
+func (s *LevelDBTrackingStore) Get(key TrackingKey) (TrackingStatus, error) {
+	var ts TrackingStatus
+	data, err := s.db.Get([]byte(key.String()), nil)
+	if errors.Is(err, leveldb.ErrNotFound) && key != key.Legacy() {
+		return s.claimLegacy(key)
+	}
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return ts, ErrTrackingStatusNotFound
+	}
+	if err != nil {
+		return ts, err
+	}
+	err = json.Unmarshal(data, &ts)
+	ts.Store = s
+	return ts, err
+}
+
+// claimLegacy moves an entry keyed only by source table to the specified
+// TrackingKey.
+func (s *LevelDBTrackingStore) claimLegacy(key TrackingKey) (TrackingStatus, error) {
+	var ts TrackingStatus
+	data, err := s.db.Get([]byte(key.Legacy().String()), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return ts, ErrTrackingStatusNotFound
+	}
+	if err != nil {
+		return ts, err
+	}
+	err = json.Unmarshal(data, &ts)
+	if err != nil {
+		return ts, err
+	}
+	if ts.DestinationTable != "" || ts.IterationID != "" {
+		return TrackingStatus{}, ErrTrackingStatusNotFound
+	}
+	ts.DestinationTable = key.DestinationTable
+	ts.IterationID = key.IterationID
+	data, err = json.Marshal(ts)
+	if err != nil {
+		return ts, err
+	}
+	batch := new(leveldb.Batch)
+	batch.Delete([]byte(key.Legacy().String()))
+	batch.Put([]byte(key.String()), data)
+	err = s.db.Write(batch, nil)
+	ts.Store = s
+	return ts, err
+}
+
+// Create writes a new TrackingStatus to the LevelDB database.
+func (s *LevelDBTrackingStore) Create(ts TrackingStatus) error {
+	return s.Update(ts)
+}
+
+// Update writes a TrackingStatus to the LevelDB database.
+func (s *LevelDBTrackingStore) Update(ts TrackingStatus) error {
+	data, err := json.Marshal(ts)
+	if err != nil {
+		return err
+	}
+	return s.db.Put([]byte(ts.Key().String()), data, nil)
+}
+
+// Close closes the LevelDB database.
+func (s *LevelDBTrackingStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
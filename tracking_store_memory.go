@@ -0,0 +1,58 @@
+package migrator
+
+import (
+	"sync"
+)
+
+// MemoryTrackingStore is a TrackingStore which holds TrackingStatus
+// objects in memory only. It is intended for testing, as positions are
+// lost when the process exits.
+type MemoryTrackingStore struct {
+	mutex    *sync.Mutex
+	statuses map[string]TrackingStatus
+}
+
+// NewMemoryTrackingStore creates an empty MemoryTrackingStore.
+func NewMemoryTrackingStore() *MemoryTrackingStore {
+	return &MemoryTrackingStore{
+		mutex:    &sync.Mutex{},
+		statuses: map[string]TrackingStatus{},
+	}
+}
+
+// Init does nothing for a MemoryTrackingStore.
+func (s *MemoryTrackingStore) Init() error {
+	return nil
+}
+
+// Get retrieves a TrackingStatus from memory.
+func (s *MemoryTrackingStore) Get(key TrackingKey) (TrackingStatus, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ts, ok := s.statuses[key.String()]
+	if !ok {
+		return TrackingStatus{}, ErrTrackingStatusNotFound
+	}
+	ts.Store = s
+	return ts, nil
+}
+
+// Create stores a new TrackingStatus in memory.
+func (s *MemoryTrackingStore) Create(ts TrackingStatus) error {
+	return s.Update(ts)
+}
+
+// Update stores a TrackingStatus in memory.
+func (s *MemoryTrackingStore) Update(ts TrackingStatus) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ts.Db = nil
+	ts.Store = nil
+	s.statuses[ts.Key().String()] = ts
+	return nil
+}
+
+// Close does nothing for a MemoryTrackingStore.
+func (s *MemoryTrackingStore) Close() error {
+	return nil
+}
@@ -0,0 +1,77 @@
+package migrator
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// MySQLTrackingStore is a TrackingStore which persists TrackingStatus
+// objects to the TrackingTableName table in a MySQL database, normally the
+// destination database. This is the default TrackingStore.
+type MySQLTrackingStore struct {
+	Db *sql.DB
+}
+
+// NewMySQLTrackingStore creates a MySQLTrackingStore using the specified
+// database connection.
+func NewMySQLTrackingStore(db *sql.DB) *MySQLTrackingStore {
+	return &MySQLTrackingStore{Db: db}
+}
+
+// Init creates the tracking table if it does not already exist.
+func (s *MySQLTrackingStore) Init() error {
+	return CreateTrackingTable(s.Db)
+}
+
+// Get retrieves a TrackingStatus from the tracking table. If none exists,
+// a row written before tracking included the destination table and
+// iteration ID is claimed for the TrackingKey, if present.
+func (s *MySQLTrackingStore) Get(key TrackingKey) (TrackingStatus, error) {
+	ts, err := GetTrackingStatus(s.Db, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		var claimed bool
+		claimed, err = ClaimLegacyTrackingStatus(s.Db, key)
+		if err != nil {
+			return ts, err
+		}
+		if claimed {
+			logger.Infof("MySQLTrackingStore.Get(): Claimed legacy tracking status for %s", key.String())
+			ts, err = GetTrackingStatus(s.Db, key)
+		} else {
+			err = sql.ErrNoRows
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrTrackingStatusNotFound
+	}
+	ts.Store = s
+	return ts, err
+}
+
+// Create inserts a new TrackingStatus into the tracking table.
+func (s *MySQLTrackingStore) Create(ts TrackingStatus) error {
+	ts.Db = s.Db
+	return SerializeNewTrackingStatus(ts)
+}
+
+// Update updates a TrackingStatus in the tracking table.
+func (s *MySQLTrackingStore) Update(ts TrackingStatus) error {
+	return SerializeTrackingStatus(s.Db, ts)
+}
+
+// InDatabase returns true if the store uses the specified database
+// connection.
+func (s *MySQLTrackingStore) InDatabase(db *sql.DB) bool {
+	return s.Db == db
+}
+
+// UpdateTx updates a TrackingStatus in the tracking table as part of an
+// existing transaction, which must belong to the store's database.
+func (s *MySQLTrackingStore) UpdateTx(tx *sql.Tx, ts TrackingStatus) error {
+	return SerializeTrackingStatusTx(tx, ts)
+}
+
+// Close does nothing, as the database connection is owned by the caller.
+func (s *MySQLTrackingStore) Close() error {
+	return nil
+}
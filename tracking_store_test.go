@@ -0,0 +1,95 @@
+package migrator
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+func TestMemoryTrackingStore(t *testing.T) {
+	store := NewMemoryTrackingStore()
+	key := TrackingKey{SourceDatabase: "src", SourceTable: "t", DestinationTable: "t2", IterationID: "a"}
+
+	_, err := store.Get(key)
+	if !errors.Is(err, ErrTrackingStatusNotFound) {
+		t.Fatalf("Get() of missing status: got %v, expected ErrTrackingStatusNotFound", err)
+	}
+
+	ts := TrackingStatus{SourceDatabase: "src", SourceTable: "t", DestinationTable: "t2", IterationID: "a", SequentialPosition: 10}
+	if err = store.Create(ts); err != nil {
+		t.Fatal(err)
+	}
+	ts.SequentialPosition = 20
+	if err = store.Update(ts); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SequentialPosition != 20 {
+		t.Errorf("SequentialPosition: got %d, expected 20", got.SequentialPosition)
+	}
+	if got.Store != store {
+		t.Errorf("Store: got %v, expected the MemoryTrackingStore", got.Store)
+	}
+
+	other := key
+	other.IterationID = "b"
+	if _, err = store.Get(other); !errors.Is(err, ErrTrackingStatusNotFound) {
+		t.Errorf("Get() of another iteration: got %v, expected ErrTrackingStatusNotFound", err)
+	}
+}
+
+func TestSerializeTrackingStatusTxAfterCommit(t *testing.T) {
+	// Neither store may use the transaction, so none is needed
+	destinationDb, err := sql.Open("mysql", "user@tcp(destination:3306)/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer destinationDb.Close()
+	otherDb, err := sql.Open("mysql", "user@tcp(other:3306)/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherDb.Close()
+
+	mysqlStore := NewMySQLTrackingStore(otherDb)
+	if mysqlStore.InDatabase(destinationDb) {
+		t.Error("InDatabase() of another database: got true, expected false")
+	}
+	if !mysqlStore.InDatabase(otherDb) {
+		t.Error("InDatabase() of the store's database: got false, expected true")
+	}
+	ts := TrackingStatus{Store: mysqlStore, SourceDatabase: "src", SourceTable: "t"}
+	afterCommit, err := serializeTrackingStatusTx(destinationDb, nil, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if afterCommit == nil {
+		t.Fatal("expected a post-commit update for a store in another database")
+	}
+
+	memoryStore := NewMemoryTrackingStore()
+	ts = TrackingStatus{Store: memoryStore, SourceDatabase: "src", SourceTable: "t", SequentialPosition: 5}
+	afterCommit, err = serializeTrackingStatusTx(destinationDb, nil, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = memoryStore.Get(ts.Key()); !errors.Is(err, ErrTrackingStatusNotFound) {
+		t.Fatalf("status stored before commit: got %v, expected ErrTrackingStatusNotFound", err)
+	}
+	if err = afterCommit(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := memoryStore.Get(ts.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SequentialPosition != 5 {
+		t.Errorf("SequentialPosition: got %d, expected 5", got.SequentialPosition)
+	}
+}
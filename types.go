@@ -1,12 +1,20 @@
 package migrator
 
 import (
+	"context"
 	"database/sql"
 )
 
 var (
 	// DefaultBatchSize represents the default size of extracted batches
 	DefaultBatchSize = 1000
+	// DefaultInsertBatchSize represents the default number of rows loaded
+	// by each statement of the default loader
+	DefaultInsertBatchSize = 100
+	// DefaultMaxAllowedPacket represents the statement size in bytes assumed
+	// by the default loader when the max_allowed_packet of the destination
+	// is not known, which is the server default before MySQL 8.0
+	DefaultMaxAllowedPacket = 4 << 20
 	// TrackingTableName represents the name of the database table used
 	// to track TrackingStatus instances, and exists within the target
 	// database.
@@ -17,6 +25,9 @@ var (
 	// ExtractorMap is a map of Extractor functions which can be used
 	// to instantiate an Extractor based only on a string.
 	ExtractorMap = make(map[string]Extractor)
+	// LoaderMap is a map of Loader functions which can be used to
+	// instantiate a Loader based only on a string.
+	LoaderMap = make(map[string]Loader)
 	// RecordQueueTable is the table name for the non-update field
 	// capable entries.
 	RecordQueueTable = "MigratorRecordQueue"
@@ -40,6 +51,11 @@ var (
 	// only polls for timestamps in the past. Boolean, defaults to
 	// false.
 	ParamOnlyPast = "OnlyPast"
+	// ParamLookback is the parameter for compound timestamp polling which
+	// only polls for timestamps at least this many seconds in the past, so
+	// that transactions which commit some time after stamping their rows
+	// are not skipped. Int, defaults to 0.
+	ParamLookback = "Lookback"
 	// ParamSequentialReplace is the parameter for loading which uses
 	// REPLACE instead of INSERT for sequentially extracted data. Boolean,
 	// defaults to false.
@@ -53,6 +69,155 @@ var (
 	// ParamTimeout is the parameter which defines the timeout for external
 	// processes or interpreters in seconds. Int, defaults to 5.
 	ParamTimeout = "Timeout"
+	// ParamSourceDsn is the parameter which is populated by the Migrator
+	// with the *mysql.Config of the source database, for extractors which
+	// need to establish their own connections.
+	ParamSourceDsn = "SourceDsn"
+	// ParamDestinationDsn is the parameter which is populated by the
+	// Migrator with the *mysql.Config of the destination database, for
+	// loaders which need its settings.
+	ParamDestinationDsn = "DestinationDsn"
+	// ParamBinlogCommand is the parameter which specifies the path to the
+	// mysqlbinlog binary used by the binlog extractor. String, defaults
+	// to "mysqlbinlog".
+	ParamBinlogCommand = "BinlogCommand"
+	// ParamBinlogServerID is the parameter which specifies the replica
+	// server ID used by the binlog extractor when connecting to the
+	// source. It must be unique across all replicas of the source. Int,
+	// defaults to a value derived from the source database and table.
+	ParamBinlogServerID = "BinlogServerID"
+	// ParamBinlogGtid is the parameter which enables tracking of the
+	// executed GTID set by the binlog extractor, which is then used to
+	// skip already applied transactions when resuming. Boolean, defaults
+	// to false.
+	ParamBinlogGtid = "BinlogGtid"
+	// ParamDeadLetterPath is the parameter which specifies the directory
+	// in which batches which fail to load are stored to be retried.
+	// String, defaults to "", which disables dead lettering.
+	ParamDeadLetterPath = "DeadLetterPath"
+	// ParamDeadLetterRetryInterval is the parameter which defines the
+	// amount of time between attempts to replay dead lettered batches in
+	// seconds. Int, defaults to 60.
+	ParamDeadLetterRetryInterval = "DeadLetterRetryInterval"
+	// ParamLagThreshold is the parameter which defines the number of
+	// seconds an Iteration may fall behind its source table before an
+	// alert is raised. Int, defaults to 0 ( disabled ).
+	ParamLagThreshold = "LagThreshold"
+	// ParamLagRowThreshold is the parameter which defines the number of
+	// keys, rows or queue entries an Iteration may fall behind its source
+	// table before an alert is raised. Int, defaults to 0 ( disabled ).
+	ParamLagRowThreshold = "LagRowThreshold"
+	// ParamLagCheckInterval is the parameter which defines the amount of
+	// time between lag checks in seconds, when a lag threshold is set.
+	// Int, defaults to 60.
+	ParamLagCheckInterval = "LagCheckInterval"
+	// ParamVerifyChunkSize is the parameter which defines the number of
+	// rows compared by each checksum when verifying an Iteration. Int,
+	// defaults to 1000.
+	ParamVerifyChunkSize = "VerifyChunkSize"
+	// ParamSnapshot is the parameter which enables copying a consistent
+	// snapshot of the source table before extracting incrementally, for
+	// Iterations which have not yet extracted anything. Boolean, defaults
+	// to false.
+	ParamSnapshot = "Snapshot"
+	// ParamSnapshotThreads is the parameter which defines the number of
+	// chunks of a snapshot copied concurrently. Int, defaults to 4.
+	ParamSnapshotThreads = "SnapshotThreads"
+	// ParamSnapshotChunkSize is the parameter which defines the number of
+	// rows in each chunk of a snapshot. Int, defaults to 10000.
+	ParamSnapshotChunkSize = "SnapshotChunkSize"
+	// ParamBackfill is the parameter which enables copying the existing
+	// contents of the source table in parallel ranges of keys before
+	// extracting incrementally, for Iterations which have not yet
+	// extracted anything. Boolean, defaults to false.
+	ParamBackfill = "Backfill"
+	// ParamBackfillThreads is the parameter which defines the number of
+	// ranges of a backfill copied concurrently. Int, defaults to 4.
+	ParamBackfillThreads = "BackfillThreads"
+	// ParamBackfillRanges is the parameter which defines the number of
+	// ranges the keys of the source table are split into by a backfill.
+	// Int, defaults to 16.
+	ParamBackfillRanges = "BackfillRanges"
+	// ParamPipeline is the parameter which enables extracting the next
+	// batch of an Iteration while the previous batch is being loaded.
+	// Boolean, defaults to false.
+	ParamPipeline = "Pipeline"
+	// ParamPipelineDepth is the parameter which defines the number of
+	// extracted batches which may be waiting to be loaded when pipelining.
+	// Int, defaults to 2.
+	ParamPipelineDepth = "PipelineDepth"
+	// ParamAdaptiveBatchSize is the parameter which enables adjusting the
+	// BatchSize and InsertBatchSize of an Iteration so that each batch
+	// takes roughly ParamBatchTargetDuration to extract, transform and
+	// load. Boolean, defaults to false.
+	ParamAdaptiveBatchSize = "AdaptiveBatchSize"
+	// ParamBatchTargetDuration is the parameter which defines the time
+	// each batch should take when adaptive batch sizing is enabled, in
+	// milliseconds. Int, defaults to 1000.
+	ParamBatchTargetDuration = "BatchTargetDuration"
+	// ParamMinBatchSize is the parameter which defines the smallest
+	// BatchSize used when adaptive batch sizing is enabled. Int, defaults
+	// to 10.
+	ParamMinBatchSize = "MinBatchSize"
+	// ParamMaxBatchSize is the parameter which defines the largest
+	// BatchSize or InsertBatchSize used when adaptive batch sizing is
+	// enabled. Int, defaults to 100000.
+	ParamMaxBatchSize = "MaxBatchSize"
+	// ParamRowsPerSecond is the parameter which limits the number of rows
+	// extracted per second. Set in the Parameters of an Iteration it
+	// limits that Iteration, and set in the Parameters of a Migrator it
+	// limits all of its Iterations together. Int, defaults to 0
+	// ( unlimited ).
+	ParamRowsPerSecond = "RowsPerSecond"
+	// ParamQueriesPerSecond is the parameter which limits the number of
+	// times per second an Extractor is run, in the same way as
+	// ParamRowsPerSecond. Int, defaults to 0 ( unlimited ).
+	ParamQueriesPerSecond = "QueriesPerSecond"
+	// ParamThrottleThreadsRunning is the parameter which defines the
+	// number of Threads_running on the source database above which
+	// extraction is held back. Int, defaults to 0 ( disabled ).
+	ParamThrottleThreadsRunning = "ThrottleThreadsRunning"
+	// ParamThrottleReplicaLag is the parameter which defines the number of
+	// seconds the source database may be behind its primary, if it is a
+	// replica, before extraction is held back. Int, defaults to 0
+	// ( disabled ).
+	ParamThrottleReplicaLag = "ThrottleReplicaLag"
+	// ParamThrottleCheckInterval is the parameter which defines the amount
+	// of time between checks of the source database when a throttle is
+	// set, in seconds. Int, defaults to 1.
+	ParamThrottleCheckInterval = "ThrottleCheckInterval"
+	// ParamRetryDelay is the parameter which defines the time to wait
+	// before retrying a stage of an Iteration which has failed once, in
+	// milliseconds. The delay doubles with each consecutive failure of the
+	// stage. Int, defaults to 1000.
+	ParamRetryDelay = "RetryDelay"
+	// ParamRetryMaxDelay is the parameter which defines the longest time
+	// to wait before retrying a failed stage of an Iteration, in
+	// milliseconds. Int, defaults to 60000.
+	ParamRetryMaxDelay = "RetryMaxDelay"
+	// ParamCircuitBreakerThreshold is the parameter which defines the
+	// number of consecutive failures of a stage after which an Iteration
+	// is stopped in the errored state. Int, defaults to 0 ( never ).
+	ParamCircuitBreakerThreshold = "CircuitBreakerThreshold"
+	// ParamLoadMethod is the parameter which overrides the method used by
+	// the DefaultLoader to load rows which are not being removed: INSERT,
+	// REPLACE or UPSERT. String, defaults to "" ( the method each row was
+	// extracted with ).
+	ParamLoadMethod = "LoadMethod"
+	// ParamUpsertColumns is the parameter which lists the columns updated
+	// when an UPSERT finds an existing row. String slice or comma separated
+	// string, defaults to all columns outside of the primary key.
+	ParamUpsertColumns = "UpsertColumns"
+	// ParamBulkCharacterSet is the parameter which defines the character
+	// set the bulk loader declares for the data it streams. String,
+	// defaults to "utf8mb4".
+	ParamBulkCharacterSet = "BulkCharacterSet"
+	// ParamMaxAllowedPacket is the parameter which defines the largest
+	// statement in bytes which the default loader may send, beyond which
+	// batches of InsertBatchSize rows are split into several statements.
+	// It is populated by the Migrator with the max_allowed_packet of the
+	// destination if not set. Int, defaults to DefaultMaxAllowedPacket.
+	ParamMaxAllowedPacket = "MaxAllowedPacket"
 )
 
 // SQLUntypedRow represents a single row of SQL data which is not strongly
@@ -76,16 +241,23 @@ type TableData struct {
 	DbName    string
 	TableName string
 	Data      []SQLRow
-	Method    string // only used with loader, specifies INSERT/REPLACE
+	Method    string // only used with loader, specifies INSERT/REPLACE/UPSERT
 }
 
-// Extractor is a callback function type
-type Extractor func(*sql.DB, string, string, TrackingStatus, *Parameters) (bool, []SQLRow, TrackingStatus, error)
+// Extractor is a callback function type. The context passed is cancelled
+// when the Migrator is stopped, and should be used for all queries.
+type Extractor func(context.Context, *sql.DB, string, string, TrackingStatus, *Parameters) (bool, []SQLRow, TrackingStatus, error)
 
 // Transformer is a callback function type which transforms an array of untyped
 // information into another array of untyped information. This is used for the
 // "transform" step of the ETL process.
 type Transformer func(string, string, []SQLRow, *Parameters) []TableData
 
-// Loader is a callback function type
-type Loader func(*sql.DB, []TableData, *Parameters) error
+// Loader is a callback function type which loads transformed data into the
+// destination database. The TrackingStatus passed represents the position
+// reached once the data has been loaded, and must only be persisted if the
+// data has been successfully committed. An empty TrackingStatus ( with no
+// SourceTable ), as passed when replaying dead lettered batches, must not
+// be persisted. The context passed is cancelled when the Migrator is
+// stopped, and should be used for all queries.
+type Loader func(context.Context, *sql.DB, []TableData, TrackingStatus, *Parameters) error
@@ -1,7 +1,9 @@
 package migrator
 
 import (
+	"maps"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -71,6 +73,55 @@ func paramBool(params Parameters, key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+// cloneParams copies a set of Parameters, so that they can be read by one
+// goroutine while the original is modified by another.
+func cloneParams(params *Parameters) *Parameters {
+	out := maps.Clone(*params)
+	return &out
+}
+
+func paramString(params Parameters, key string, defaultValue string) string {
+	out := defaultValue
+	if _, ok := params[key]; ok {
+		out, ok = params[key].(string)
+		if !ok {
+			return defaultValue
+		}
+		return out
+	}
+	return defaultValue
+}
+
+// paramStrings reads a list of strings, which may be specified as a
+// []string, as the []any produced by decoding YAML or JSON, or as a comma
+// separated string.
+func paramStrings(params Parameters, key string, defaultValue []string) []string {
+	switch v := params[key].(type) {
+	case []string:
+		return v
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, s := range v {
+			str, ok := s.(string)
+			if !ok {
+				return defaultValue
+			}
+			out = append(out, str)
+		}
+		return out
+	case string:
+		out := make([]string, 0)
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+	}
+	return defaultValue
+}
+
 // FileExists reports whether the named file or directory exists.
 func FileExists(name string) bool {
 	if _, err := os.Stat(name); err != nil {
@@ -0,0 +1,343 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// VerifyRange describes the comparison of the source and destination
+// tables of an Iteration over a single range of primary keys. Lower is
+// exclusive and Upper is inclusive; both are key tuples encoded by
+// EncodeKeyTuple, and are empty if the range is unbounded.
+type VerifyRange struct {
+	Lower               string
+	Upper               string
+	SourceRows          int64
+	DestinationRows     int64
+	SourceChecksum      int64
+	DestinationChecksum int64
+}
+
+// String returns a human readable description of the range.
+func (r VerifyRange) String() string {
+	lower, upper := r.Lower, r.Upper
+	if lower == "" {
+		lower = "-inf"
+	}
+	if upper == "" {
+		upper = "+inf"
+	}
+	return fmt.Sprintf("( %s, %s ]: rows %d / %d, checksum %d / %d", lower, upper, r.SourceRows, r.DestinationRows, r.SourceChecksum, r.DestinationChecksum)
+}
+
+// VerifyResult is the result of comparing the source and destination tables
+// of an Iteration.
+type VerifyResult struct {
+	// ID is the optional ID of the Iteration
+	ID string
+	// SourceTable is the table from which the Iteration extracts data
+	SourceTable string
+	// DestinationTable is the table into which the Iteration loads data,
+	// as renamed by its Transformer
+	DestinationTable string
+	// KeyColumns are the primary key columns of the source table, over
+	// ranges of which the tables are compared
+	KeyColumns []string
+	// Columns are the columns present in both tables, which are compared
+	Columns []string
+	// Position describes the tracked position which limited the
+	// comparison, if any
+	Position string
+	// SourceRows is the number of source rows compared
+	SourceRows int64
+	// DestinationRows is the number of destination rows compared
+	DestinationRows int64
+	// Chunks is the number of ranges compared
+	Chunks int
+	// Mismatches are the ranges which differ between the tables
+	Mismatches []VerifyRange
+	// InFlight are the ranges which differ between the tables, but which
+	// contain changes which the Iteration has not yet extracted
+	InFlight []VerifyRange
+
+	// filter limits the rows compared to those already extracted
+	filter keyCondition
+}
+
+// Matched determines whether the source and destination tables matched.
+func (r VerifyResult) Matched() bool {
+	return len(r.Mismatches) == 0
+}
+
+// Verify compares the source and destination tables of a single Iteration,
+// identified by ID or source table name, by row count and by checksums of
+// each ParamVerifyChunkSize rows in primary key order. Only rows which the
+// Iteration has already extracted are expected to match: for the sequential
+// and keyset extractors rows beyond the tracked position are not compared,
+// and for the timestamp and queue extractors ranges containing changes
+// which have not yet been extracted are reported as InFlight rather than as
+// Mismatches. The Migrator must be initialized, but need not be running.
+func (m *Migrator) Verify(ctx context.Context, name string) (VerifyResult, error) {
+	x, err := m.findIteration(name)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	return m.verify(ctx, x)
+}
+
+// VerifyAll compares the source and destination tables of all Iterations,
+// in the order in which they are defined. See Verify.
+func (m *Migrator) VerifyAll(ctx context.Context) ([]VerifyResult, error) {
+	out := make([]VerifyResult, 0, len(m.Iterations))
+	for x := range m.Iterations {
+		result, err := m.verify(ctx, x)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, result)
+	}
+	return out, nil
+}
+
+// destinationTableName determines the table into which an Iteration loads
+// data by passing an empty batch through its Transformer, so that
+// transformers which rename tables, such as TableRenamerTransformer, are
+// taken into account.
+func (m *Migrator) destinationTableName(x int) string {
+	data := m.Iterations[x].Transformer(m.DestinationDsn.DBName, m.Iterations[x].DestinationTable, []SQLRow{}, m.Iterations[x].TransformerParameters)
+	if len(data) == 1 && data[0].TableName != "" {
+		return data[0].TableName
+	}
+	return m.Iterations[x].DestinationTable
+}
+
+func (m *Migrator) verify(ctx context.Context, x int) (VerifyResult, error) {
+	tag := "Migrator.Verify(): [" + m.trackingKey(x).String() + "] "
+	chunkSize := paramInt(*m.Iterations[x].Parameters, ParamVerifyChunkSize, 1000)
+	if chunkSize < 1 {
+		chunkSize = 1000
+	}
+
+	m.mutex.Lock()
+	sourceDb, destinationDb := m.sourceDb, m.destinationDb
+	m.mutex.Unlock()
+	if sourceDb == nil || destinationDb == nil || m.trackingStore == nil {
+		return VerifyResult{}, errors.New(tag + "Not initialized")
+	}
+
+	out := VerifyResult{
+		ID:               m.Iterations[x].ID,
+		SourceTable:      m.Iterations[x].SourceTable,
+		DestinationTable: m.destinationTableName(x),
+		Mismatches:       make([]VerifyRange, 0),
+		InFlight:         make([]VerifyRange, 0),
+	}
+
+	ts, err := m.trackingStore.Get(m.trackingKey(x))
+	if err != nil {
+		return out, err
+	}
+
+	out.KeyColumns, err = primaryKeyColumns(ctx, sourceDb, m.SourceDsn.DBName, out.SourceTable)
+	if err != nil {
+		return out, err
+	}
+	if len(out.KeyColumns) == 0 {
+		return out, errors.New(tag + "Source table " + out.SourceTable + " has no primary key")
+	}
+
+	sourceCols, err := tableColumnNames(ctx, sourceDb, m.SourceDsn.DBName, out.SourceTable)
+	if err != nil {
+		return out, err
+	}
+	destinationCols, err := tableColumnNames(ctx, destinationDb, m.DestinationDsn.DBName, out.DestinationTable)
+	if err != nil {
+		return out, err
+	}
+	if len(destinationCols) == 0 {
+		return out, errors.New(tag + "Destination table " + out.DestinationTable + " does not exist")
+	}
+	present := map[string]bool{}
+	for _, c := range destinationCols {
+		present[strings.ToLower(c)] = true
+	}
+	out.Columns = make([]string, 0, len(sourceCols))
+	for _, c := range sourceCols {
+		if present[strings.ToLower(c)] {
+			out.Columns = append(out.Columns, c)
+		}
+	}
+	for _, k := range out.KeyColumns {
+		if !present[strings.ToLower(k)] {
+			return out, errors.New(tag + "Destination table " + out.DestinationTable + " is missing key column " + k)
+		}
+	}
+
+	filter, inFlight, err := m.verifyPosition(ctx, x, ts, out.KeyColumns, &out)
+	if err != nil {
+		return out, err
+	}
+	out.filter = filter
+
+	logger.Infof(tag+"Comparing %s.%s to %s.%s in chunks of %d rows", m.SourceDsn.DBName, out.SourceTable, m.DestinationDsn.DBName, out.DestinationTable, chunkSize)
+	start := time.Now()
+
+	var lower []any
+	for {
+		if ctx.Err() != nil {
+			return out, ctx.Err()
+		}
+
+		upper, err := chunkBoundary(ctx, sourceDb, out.SourceTable, out.KeyColumns, filter.keyRange(out.KeyColumns, lower, nil), chunkSize)
+		if err != nil {
+			return out, err
+		}
+
+		r, err := verifyChunk(ctx, sourceDb, destinationDb, out, filter.keyRange(out.KeyColumns, lower, upper))
+		if err != nil {
+			return out, err
+		}
+		out.Chunks++
+		out.SourceRows += r.SourceRows
+		out.DestinationRows += r.DestinationRows
+
+		if r.SourceRows != r.DestinationRows || r.SourceChecksum != r.DestinationChecksum {
+			if lower != nil {
+				r.Lower, err = EncodeKeyTuple(lower)
+				if err != nil {
+					return out, err
+				}
+			}
+			if upper != nil {
+				r.Upper, err = EncodeKeyTuple(upper)
+				if err != nil {
+					return out, err
+				}
+			}
+			pending := false
+			if inFlight != nil {
+				pending, err = inFlight(lower, upper)
+				if err != nil {
+					return out, err
+				}
+			}
+			if pending {
+				logger.Debugf(tag+"In flight %s", r.String())
+				out.InFlight = append(out.InFlight, r)
+			} else {
+				logger.Warnf(tag+"Mismatch %s", r.String())
+				out.Mismatches = append(out.Mismatches, r)
+			}
+		}
+
+		if upper == nil {
+			break
+		}
+		lower = upper
+	}
+
+	logger.Infof(tag+"Compared %d / %d rows in %d chunks in %s, %d mismatched, %d in flight", out.SourceRows, out.DestinationRows, out.Chunks, time.Since(start).String(), len(out.Mismatches), len(out.InFlight))
+	return out, nil
+}
+
+// verifyPosition determines how the tracked position of an Iteration
+// limits the comparison of its tables. Extractors which track an immutable
+// key return a filter which excludes rows beyond the position from both
+// tables. Extractors which track mutable values return a function which
+// determines whether a range of keys contains changes which have not yet
+// been extracted.
+func (m *Migrator) verifyPosition(ctx context.Context, x int, ts TrackingStatus, keyCols []string, out *VerifyResult) (keyCondition, func(lower, upper []any) (bool, error), error) {
+	m.mutex.Lock()
+	sourceDb := m.sourceDb
+	m.mutex.Unlock()
+
+	var stampCond keyCondition
+
+	switch m.Iterations[x].ExtractorName {
+	case "sequential":
+		out.Position = fmt.Sprintf("%s <= %d", ts.ColumnName, ts.SequentialPosition)
+		return keyCondition{where: "`" + ts.ColumnName + "` <= ?", args: []any{ts.SequentialPosition}}, nil, nil
+
+	case "keyset":
+		if ts.KeyPosition == "" {
+			out.Position = "nothing extracted"
+			return keyCondition{where: "0 = 1"}, nil, nil
+		}
+		position, err := DecodeKeyTuple(ts.KeyPosition)
+		if err != nil {
+			return keyCondition{}, nil, err
+		}
+		cols := keysetColumns(ts.ColumnName)
+		if len(position) != len(cols) {
+			return keyCondition{}, nil, fmt.Errorf("key position %s does not match key columns %s", ts.KeyPosition, ts.ColumnName)
+		}
+		out.Position = ts.ColumnName + " <= " + ts.KeyPosition
+		where, args := keysetBefore(cols, position, true)
+		return keyCondition{where: where, args: args}, nil, nil
+
+	case "timestamp", "timestamp_fallback", "timestamp_keyset":
+		expr := "`" + ts.ColumnName + "`"
+		if m.Iterations[x].ExtractorName == "timestamp_fallback" {
+			cols := keysetColumns(ts.ColumnName)
+			if len(cols) < 2 {
+				return keyCondition{}, nil, errors.New("timestamp_fallback requires two columns separated by a comma")
+			}
+			expr = "IFNULL(`" + cols[0] + "`,`" + cols[1] + "`)"
+		} else if m.Iterations[x].ExtractorName == "timestamp_keyset" {
+			expr = "`" + keysetColumns(ts.ColumnName)[0] + "`"
+		}
+		if !ts.TimestampPosition.Valid {
+			out.Position = "nothing extracted"
+			stampCond = keyCondition{where: "1 = 1"}
+		} else {
+			// Rows sharing the position timestamp may not all have been
+			// extracted by the timestamp_keyset extractor
+			out.Position = expr + " <= " + ts.TimestampPosition.Time.String()
+			stampCond = keyCondition{where: expr + " >= ?", args: []any{ts.TimestampPosition.Time}}
+		}
+		return keyCondition{}, func(lower, upper []any) (bool, error) {
+			var count int64
+			c := stampCond.keyRange(keyCols, lower, upper)
+			err := sourceDb.QueryRowContext(ctx, "SELECT COUNT(*) FROM `"+out.SourceTable+"`"+c.clause(), c.args...).Scan(&count)
+			return count > 0, err
+		}, nil
+
+	case "queue":
+		if len(keyCols) != 1 {
+			return keyCondition{}, nil, errors.New("queue verification requires a single column primary key")
+		}
+		out.Position = "excluding queued rows"
+		return keyCondition{}, func(lower, upper []any) (bool, error) {
+			var count int64
+			c := keyCondition{where: "sourceDatabase = ? AND sourceTable = ?", args: []any{m.SourceDsn.DBName, out.SourceTable}}
+			c = c.keyRange([]string{"pkValue"}, lower, upper)
+			err := sourceDb.QueryRowContext(ctx, "SELECT COUNT(*) FROM `"+RecordQueueTable+"`"+c.clause(), c.args...).Scan(&count)
+			return count > 0, err
+		}, nil
+	}
+
+	// Positions which cannot be related to rows, such as binlog positions,
+	// do not limit the comparison
+	return keyCondition{}, nil, nil
+}
+
+// verifyChunk counts and checksums the rows matching a condition in both
+// the source and destination tables. The checksum is the BIT_XOR of the
+// CRC32 of each row, which does not depend on the order of the rows.
+func verifyChunk(ctx context.Context, sourceDb, destinationDb *sql.DB, result VerifyResult, c keyCondition) (VerifyRange, error) {
+	cols := "`" + strings.Join(result.Columns, "`, `") + "`"
+	nulls := "ISNULL(`" + strings.Join(result.Columns, "`), ISNULL(`") + "`)"
+	checksum := "SELECT COUNT(*), COALESCE(BIT_XOR(CRC32(CONCAT_WS('#', " + cols + ", CONCAT(" + nulls + ")))), 0) FROM "
+
+	var r VerifyRange
+	err := sourceDb.QueryRowContext(ctx, checksum+"`"+result.SourceTable+"`"+c.clause(), c.args...).Scan(&r.SourceRows, &r.SourceChecksum)
+	if err != nil {
+		return r, err
+	}
+	err = destinationDb.QueryRowContext(ctx, checksum+"`"+result.DestinationTable+"`"+c.clause(), c.args...).Scan(&r.DestinationRows, &r.DestinationChecksum)
+	return r, err
+}